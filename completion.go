@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 新增 flag 时同步更新这里，补全脚本由它生成
+var completionFlags = []struct {
+	Name  string
+	Short string
+	Arg   bool
+	Desc  string
+}{
+	{"--cmd", "", true, "The command to run as one string, instead of -- <command>"},
+	{"--script", "", true, "Run a script file as the command"},
+	{"--bind", "", true, "Map a key to an action"},
+	{"--bindfile", "", true, "Read key:action bindings from a file"},
+	{"--watch", "", true, "Reload the command when a file changes"},
+	{"--passthrough", "", true, "Always forward these keys to the command"},
+	{"--hold", "-h", false, "Wait for a key after the command exits"},
+	{"--hold-message", "", true, "Message shown while holding"},
+	{"--input", "", true, "Feed literal text into the command"},
+	{"--input-file", "", true, "Feed a file into the command"},
+	{"--echo-input", "", false, "Type --input visibly, one character at a time"},
+	{"--input-delay", "", true, "Wait this long before feeding input"},
+	{"--no-stdin", "", false, "Pass piped stdin to the command as a live stream"},
+	{"--shell", "", true, "Shell used for become/execute"},
+	{"--cwd", "", true, "Working directory of the command"},
+	{"--path", "", true, "Prepend a directory to PATH for the command"},
+	{"--term", "", true, "TERM of the command, e.g. xterm-256color"},
+	{"--colorterm", "", true, "COLORTERM of the command, e.g. truecolor"},
+	{"--env", "", true, "Set an environment variable (KEY=VALUE)"},
+	{"--buffer-size", "", true, "Read buffer size in bytes"},
+	{"--log-json", "", true, "Log every key press as JSON to a file"},
+	{"--separate-stderr", "", false, "Send the command's stderr to stderr, not the pty"},
+	{"--output-log", "", true, "Copy everything the command prints to a file"},
+	{"--output-log-plain", "", true, "Like --output-log, without escape sequences"},
+	{"--record", "", true, "Record the session as an asciinema cast file"},
+	{"--timeout", "", true, "Exit after this long without a key press"},
+	{"--execute-timeout", "", true, "Kill execute commands that run longer than this"},
+	{"--max-output-bytes", "", true, "Stop after the command printed this many bytes"},
+	{"--scrollback", "", true, "Bytes of output replayed after reload"},
+	{"--fallback", "", false, "Split the command on -- into fallback commands"},
+	{"--retry", "", true, "Restart a command that fails to start this many times"},
+	{"--retry-delay", "", true, "Wait this long before each retry (default 1s)"},
+	{"--size", "", true, "Fix the pty size, e.g. 80x24 or x-2"},
+	{"--title", "", true, "Set the terminal window title"},
+	{"--header", "", true, "Show a fixed line above the command"},
+	{"--alt-screen", "", false, "Run in the alternate screen and restore it on exit"},
+	{"--mouse", "", false, "Enable mouse reporting and mouse bindings"},
+	{"--keys", "", false, "Show the name of each key pressed, Ctrl-C to quit"},
+	{"--dry-run", "", false, "Print the command and resolved keymap, then exit"},
+	{"--once", "", false, "Exit after the first bound action, ignore other keys"},
+	{"--no-forward", "", false, "Ignore unbound keys instead of forwarding them"},
+	{"--on-exit", "", true, "Run a shell command when keywrap exits"},
+	{"--kill-signal", "", true, "Signal sent to stop the command (TERM, HUP, INT, ...)"},
+	{"--kill-timeout", "", true, "Wait this long before SIGKILL, 0 kills at once"},
+	{"--clipboard", "", true, "How copy() sets the clipboard: auto, osc52 or a command"},
+	{"--strict", "", false, "Treat conflicting bindings as an error"},
+	{"--quiet", "-q", false, "Do not print diagnostics while running"},
+	{"--completion", "", true, "Print a completion script (bash, zsh, fish)"},
+	{"--version", "-V", false, "Print the version"},
+}
+
+var completionActions = []string{
+	"exit", "abort", "reload", "refresh", "help", "pause", "resume",
+	"become(", "become-tty(", "become-stdin(", "execute(", "execute-silent(", "execute-raw(", "execute-async(", "print(", "put(", "send-keys(", "send-stdin(", "copy(", "edit(", "screenshot(", "signal(", "mode(", "toggle(",
+}
+
+func completionScript(shell string) (string, error) {
+	var flags []string
+	for _, f := range completionFlags {
+		flags = append(flags, f.Name)
+		if f.Short != "" {
+			flags = append(flags, f.Short)
+		}
+	}
+	flags = append(flags, "--")
+	actions := strings.Join(completionActions, " ")
+
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(bashCompletion, actions, strings.Join(flags, " ")), nil
+	case "zsh":
+		return fmt.Sprintf(zshCompletion, actions, strings.Join(flags, " ")), nil
+	case "fish":
+		var b strings.Builder
+		for _, f := range completionFlags {
+			fmt.Fprintf(&b, "complete -c keywrap -l %s", strings.TrimPrefix(f.Name, "--"))
+			if f.Short != "" {
+				fmt.Fprintf(&b, " -s %s", strings.TrimPrefix(f.Short, "-"))
+			}
+			if f.Arg {
+				b.WriteString(" -r")
+			}
+			fmt.Fprintf(&b, " -d '%s'\n", f.Desc)
+		}
+		fmt.Fprintf(&b, "complete -c keywrap -n '__fish_prev_arg_in --bind' -f -a '%s'\n", actions)
+		b.WriteString("complete -c keywrap -n '__fish_prev_arg_in --completion' -f -a 'bash zsh fish'\n")
+		return b.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported shell for --completion: %s", shell)
+	}
+}
+
+const bashCompletion = `_keywrap() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        if [[ ${COMP_WORDS[i]} == -- ]]; then
+            COMPREPLY=($(compgen -c -- "$cur"))
+            return
+        fi
+    done
+    case $prev in
+    --bind | :)
+        compopt -o nospace
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        ;;
+    --bindfile | --script | --input-file | --cwd | --path | --log-json | --output-log | --output-log-plain | --record | --watch)
+        COMPREPLY=($(compgen -f -- "$cur"))
+        ;;
+    --completion)
+        COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+        ;;
+    *)
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        ;;
+    esac
+}
+complete -F _keywrap keywrap
+`
+
+const zshCompletion = `#compdef keywrap
+
+_keywrap() {
+  local i
+  for ((i = 2; i < CURRENT; i++)); do
+    if [[ ${words[i]} == -- ]]; then
+      shift $i words
+      (( CURRENT -= i ))
+      _normal
+      return
+    fi
+  done
+  case ${words[CURRENT-1]} in
+    --bind) compadd -S '' -- ${=:-"%s"} ;;
+    --bindfile|--script|--input-file|--log-json|--output-log|--output-log-plain|--record|--watch) _files ;;
+    --cwd|--path) _files -/ ;;
+    --completion) compadd bash zsh fish ;;
+    *) compadd -- ${=:-"%s"} ;;
+  esac
+}
+
+_keywrap "$@"
+`
@@ -5,7 +5,6 @@ go 1.24.2
 require (
 	github.com/creack/pty v1.1.24
 	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203
+	golang.org/x/sys v0.33.0
 	golang.org/x/term v0.32.0
 )
-
-require golang.org/x/sys v0.33.0 // indirect
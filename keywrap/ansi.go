@@ -0,0 +1,70 @@
+package keywrap
+
+import "io"
+
+// ansiStripper 去掉写入内容中的 ANSI 转义序列和 \r，只保留可读文本。
+// 转义序列可能被拆在两次 Write 之间，所以需要记住解析状态
+type ansiStripper struct {
+	w     io.Writer
+	state int
+}
+
+const (
+	ansiText   = iota
+	ansiEsc    // 收到 ESC
+	ansiCSI    // ESC [ ... 终止字节
+	ansiString // OSC/DCS 等字符串，以 BEL 或 ESC \ 结束
+	ansiStrEsc // 字符串中收到 ESC
+)
+
+func newANSIStripper(w io.Writer) *ansiStripper {
+	return &ansiStripper{w: w}
+}
+
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, c := range p {
+		switch s.state {
+		case ansiText:
+			switch c {
+			case '\x1b':
+				s.state = ansiEsc
+			case '\r':
+			default:
+				out = append(out, c)
+			}
+		case ansiEsc:
+			switch {
+			case c == '[':
+				s.state = ansiCSI
+			case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+				s.state = ansiString
+			case c >= 0x20 && c <= 0x2f:
+				// 中间字节，例如 ESC ( B，继续等待最终字节
+			default:
+				s.state = ansiText
+			}
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				s.state = ansiText
+			}
+		case ansiString:
+			switch c {
+			case '\x07':
+				s.state = ansiText
+			case '\x1b':
+				s.state = ansiStrEsc
+			}
+		case ansiStrEsc:
+			if c == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiString
+			}
+		}
+	}
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
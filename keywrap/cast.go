@@ -0,0 +1,59 @@
+package keywrap
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// castWriter 把输出写成 asciinema v2 的 .cast 格式：第一行是 header，之后每行一个 [时间, "o", 数据] 事件
+type castWriter struct {
+	w       io.Writer
+	start   time.Time
+	pending []byte // 被拆开的 UTF-8 字符留到下一次写入
+}
+
+func newCastWriter(w io.Writer, width, height int) (*castWriter, error) {
+	start := time.Now()
+	header, err := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": start.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return nil, err
+	}
+	return &castWriter{w: w, start: start}, nil
+}
+
+func (c *castWriter) Write(p []byte) (int, error) {
+	data := append(c.pending, p...)
+	cut := len(data)
+	// 末尾最多 3 个字节可能是不完整的字符
+	for i := len(data) - 1; i >= 0 && i >= len(data)-3; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	c.pending = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return len(p), nil
+	}
+
+	event, err := json.Marshal([]any{time.Since(c.start).Seconds(), "o", string(data[:cut])})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.w.Write(append(event, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
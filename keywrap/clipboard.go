@@ -0,0 +1,48 @@
+package keywrap
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// 按顺序尝试的剪贴板工具，都从 stdin 读取内容
+var clipboardCommands = []struct {
+	name string
+	args []string
+	env  string // 不为空时只在设置了该环境变量的会话中使用
+}{
+	{"pbcopy", nil, ""},
+	{"wl-copy", nil, "WAYLAND_DISPLAY"},
+	{"xclip", []string{"-selection", "clipboard"}, "DISPLAY"},
+	{"xsel", []string{"--clipboard", "--input"}, "DISPLAY"},
+}
+
+// 把 text 放到系统剪贴板。method 为 osc52 时向 w 写 OSC 52 转义序列，
+// 由终端设置剪贴板，通过 ssh 也能用；为空或 auto 时使用找到的第一个剪贴板工具，
+// 都找不到再退回 OSC 52；其他值作为 shell 命令执行，从 stdin 读取内容
+func copyToClipboard(w io.Writer, shell, method, text string) error {
+	switch method {
+	case "osc52":
+		_, err := io.WriteString(w, "\x1b]52;c;"+base64.StdEncoding.EncodeToString([]byte(text))+"\x07")
+		return err
+	case "", "auto":
+		for _, c := range clipboardCommands {
+			if c.env != "" && os.Getenv(c.env) == "" {
+				continue
+			}
+			if path, err := exec.LookPath(c.name); err == nil {
+				cmd := exec.Command(path, c.args...)
+				cmd.Stdin = strings.NewReader(text)
+				return cmd.Run()
+			}
+		}
+		return copyToClipboard(w, shell, "osc52", text)
+	default:
+		cmd := exec.Command(shell, "-c", method)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+}
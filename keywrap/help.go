@@ -0,0 +1,32 @@
+package keywrap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// 帮助界面的内容，每个绑定一行。原始模式下换行不会回到行首，所以使用 \r\n
+func keymapHelp(keymap map[string]string) string {
+	var keys []string
+	for k := range keymap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tACTION\tDESCRIPTION")
+	for _, k := range keys {
+		var actions []string
+		var desc string
+		for _, a := range parseActions(keymap[k]) {
+			actions = append(actions, a.String())
+			desc = a.Desc
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", k, strings.Join(actions, "+"), desc)
+	}
+	tw.Flush()
+	return strings.ReplaceAll(b.String(), "\n", "\r\n")
+}
@@ -0,0 +1,897 @@
+package keywrap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+type Action struct {
+	Type ActionType
+	Arg  string
+	Args []string // toggle 的两条命令
+	Key  string   // 触发该 action 的按键名，例如 ctrl-e
+	Desc string   // 绑定的说明，用于帮助界面和按键日志
+}
+
+func (a Action) String() string {
+	if a.Arg == "" {
+		return string(a.Type)
+	}
+	return string(a.Type) + "(" + a.Arg + ")"
+}
+
+type ActionType string
+
+const (
+	ActionTypeExit    ActionType = "exit"
+	ActionTypeBecome  ActionType = "become"
+	ActionTypeExecute ActionType = "execute"
+	ActionTypeReload  ActionType = "reload"
+
+	ActionTypeExecuteSilent ActionType = "execute-silent"
+	ActionTypePrint         ActionType = "print"
+	ActionTypeAbort         ActionType = "abort"
+	ActionTypeSend          ActionType = "send-keys"
+	ActionTypeToggle        ActionType = "toggle"
+	ActionTypeBecomeTty     ActionType = "become-tty"
+	ActionTypeBecomeStdin   ActionType = "become-stdin"
+	ActionTypeRefresh       ActionType = "refresh"
+	ActionTypeExecuteRaw    ActionType = "execute-raw"
+	ActionTypeSendStdin     ActionType = "send-stdin"
+	ActionTypeCopy          ActionType = "copy"
+	ActionTypeExecuteAsync  ActionType = "execute-async"
+	ActionTypeSignal        ActionType = "signal"
+	ActionTypeHelp          ActionType = "help"
+	ActionTypeEdit          ActionType = "edit"
+	ActionTypePause         ActionType = "pause"
+	ActionTypeResume        ActionType = "resume"
+	ActionTypeScreenshot    ActionType = "screenshot"
+	ActionTypeMode          ActionType = "mode"
+)
+
+// 不同终端对同一个键发送的序列不同，这里把常见的序列都注册上:
+//   - f1-f4:  xterm 的 SS3 形式 (\x1bOP..\x1bOS)、vt220/rxvt 的 \x1b[11~..\x1b[14~、
+//     linux console 的 \x1b[[A..\x1b[[D
+//   - f5:     \x1b[15~ 以及 linux console 的 \x1b[[E
+//   - f6-f12: \x1b[17~..\x1b[24~ (xterm 与 linux console 一致，中间跳过 16 和 22)
+//   - 方向键: 普通模式 \x1b[A..\x1b[D 与应用模式 (DECCKM) \x1bOA..\x1bOD
+//   - home/end: xterm 的 \x1b[H/\x1b[F 与应用模式 \x1bOH/\x1bOF、vt220 的 \x1b[1~/\x1b[4~、
+//     rxvt 的 \x1b[7~/\x1b[8~
+var keySequences = map[string][]string{
+	"f1":  {"\x1bOP", "\x1b[11~", "\x1b[[A"},
+	"f2":  {"\x1bOQ", "\x1b[12~", "\x1b[[B"},
+	"f3":  {"\x1bOR", "\x1b[13~", "\x1b[[C"},
+	"f4":  {"\x1bOS", "\x1b[14~", "\x1b[[D"},
+	"f5":  {"\x1b[15~", "\x1b[[E"},
+	"f6":  {"\x1b[17~"},
+	"f7":  {"\x1b[18~"},
+	"f8":  {"\x1b[19~"},
+	"f9":  {"\x1b[20~"},
+	"f10": {"\x1b[21~"},
+	"f11": {"\x1b[23~"},
+	"f12": {"\x1b[24~"},
+
+	"up":    {"\x1b[A", "\x1bOA"},
+	"down":  {"\x1b[B", "\x1bOB"},
+	"right": {"\x1b[C", "\x1bOC"},
+	"left":  {"\x1b[D", "\x1bOD"},
+
+	"home":      {"\x1b[H", "\x1bOH", "\x1b[1~", "\x1b[7~"},
+	"end":       {"\x1b[F", "\x1bOF", "\x1b[4~", "\x1b[8~"},
+	"pgup":      {"\x1b[5~"},
+	"page-up":   {"\x1b[5~"},
+	"pgdn":      {"\x1b[6~"},
+	"page-down": {"\x1b[6~"},
+
+	"esc":   {"\x1b"},
+	"space": {" "},
+
+	// 终端的 Backspace 发送 DEL (0x7f)，Delete 键发送 \x1b[3~，两者互不影响。
+	// 发送 ^H 的终端上 Backspace 需要绑定 ctrl-h
+	"bspace":    {"\x7f"},
+	"backspace": {"\x7f"},
+	"delete":    {"\x1b[3~"},
+	"del":       {"\x1b[3~"},
+	"insert":    {"\x1b[2~"},
+	"ins":       {"\x1b[2~"},
+
+	"ctrl-space": {"\x00", "\x1b[32;5u"},
+
+	// 鼠标事件 (需要 --mouse)，坐标被 normalizeMouse 去掉
+	"left-click":   {"\x1b[<0M"},
+	"middle-click": {"\x1b[<1M"},
+	"right-click":  {"\x1b[<2M"},
+	"scroll-up":    {"\x1b[<64M"},
+	"scroll-down":  {"\x1b[<65M"},
+
+	"shift-tab": {"\x1b[Z"},
+	"btab":      {"\x1b[Z"},
+
+	// 小键盘在应用模式 (DECKPAM) 下发送的 SS3 序列，普通模式下与主键盘相同
+	"kp-0":        {"\x1bOp"},
+	"kp-1":        {"\x1bOq"},
+	"kp-2":        {"\x1bOr"},
+	"kp-3":        {"\x1bOs"},
+	"kp-4":        {"\x1bOt"},
+	"kp-5":        {"\x1bOu"},
+	"kp-6":        {"\x1bOv"},
+	"kp-7":        {"\x1bOw"},
+	"kp-8":        {"\x1bOx"},
+	"kp-9":        {"\x1bOy"},
+	"kp-enter":    {"\x1bOM"},
+	"kp-plus":     {"\x1bOk"},
+	"kp-minus":    {"\x1bOm"},
+	"kp-multiply": {"\x1bOj"},
+	"kp-divide":   {"\x1bOo"},
+	"kp-decimal":  {"\x1bOn"},
+	"kp-equal":    {"\x1bOX"},
+
+	// 括号粘贴结束时触发，粘贴的内容本身不会匹配任何绑定
+	"paste": {pasteEnd},
+}
+
+// 其他工具中常见的按键名写法
+var keyAliases = map[string]string{
+	"return":   "enter",
+	"escape":   "esc",
+	"prior":    "pgup",
+	"pageup":   "pgup",
+	"next":     "pgdn",
+	"pagedown": "pgdn",
+}
+
+// 修饰键前缀的写法，按 ctrl-、alt-、shift- 处理
+var modifierAliases = map[string]string{
+	"ctrl-":    "ctrl-",
+	"control-": "ctrl-",
+	"alt-":     "alt-",
+	"meta-":    "alt-",
+	"shift-":   "shift-",
+}
+
+// 把 Ctrl-E、ENTER、Return 这样的写法统一成小写的标准按键名。
+// 单个字符区分大小写 (alt-E 与 alt-e 不同)，不认识的名字 (例如连按 gG) 保持原样
+func normalizeKey(k string) string {
+	if len(k) <= 1 || isRawKey(k) {
+		return k
+	}
+	var mods string
+	rest := k
+prefix:
+	for len(rest) > 1 {
+		for alias, mod := range modifierAliases {
+			if len(rest) > len(alias) && strings.EqualFold(rest[:len(alias)], alias) {
+				mods += mod
+				rest = rest[len(alias):]
+				continue prefix
+			}
+		}
+		break
+	}
+	if len(rest) == 1 {
+		return mods + rest
+	}
+	name := strings.ToLower(rest)
+	if alias, ok := keyAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := keySequences[mods+name]; ok || name == "enter" || name == "tab" || mods != "" {
+		return mods + name
+	}
+	if _, ok := keySequences[name]; ok {
+		return name
+	}
+	return k
+}
+
+// 把按键名转换成终端发送的字节序列，存在无法识别的按键时返回错误
+func formatKeymap(keymap map[string]string) (map[string][]Action, error) {
+	m := make(map[string][]Action)
+	var unknown []string
+	for name, v := range keymap {
+		actions := parseActions(v)
+		for i := range actions {
+			actions[i].Key = name
+		}
+
+		k := normalizeKey(name)
+		switch {
+		case isRawKey(k):
+			// 由 rawKeymap 处理
+		case len(k) == 1:
+			m[k] = actions
+		case len(modifiedKey(k)) > 0:
+			for _, seq := range modifiedKey(k) {
+				m[seq] = actions
+			}
+		case len(keySequences[k]) > 0:
+			for _, seq := range keySequences[k] {
+				m[seq] = actions
+			}
+		case k == "enter":
+			m["\n"] = actions
+		case k == "tab":
+			m["\t"] = actions
+		case isChord(k):
+			m[k] = actions // 依次按下的多个字符，例如 gg
+		default:
+			if similar := similarKey(k); similar != "" && !fKeyName.MatchString(k) {
+				name += " (did you mean " + similar + "?)"
+			}
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown key: %s\naccepted keys: %s", strings.Join(unknown, ", "), strings.Join(keyNames(), ", "))
+	}
+	return m, nil
+}
+
+// 找出解析成同一个字节序列的不同按键，例如 tab 和 ctrl-i。
+// 这时只有其中一个绑定生效，返回的每一项描述一处冲突
+func keymapConflicts(keymap map[string]string) []string {
+	var keys []string
+	for k := range keymap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	owners := make(map[string]string)
+	var conflicts []string
+	for _, k := range keys {
+		// 不同模式中的绑定互不冲突
+		mode, key := splitModeKey(k)
+		m, err := formatKeymap(map[string]string{key: keymap[k]})
+		if err != nil {
+			continue
+		}
+		var seqs []string
+		for seq := range m {
+			seqs = append(seqs, seq)
+		}
+		sort.Strings(seqs)
+		for _, seq := range seqs {
+			if owner, ok := owners[mode+":"+seq]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("%s and %s both match %q", owner, k, seq))
+				continue
+			}
+			owners[mode+":"+seq] = k
+		}
+	}
+	return conflicts
+}
+
+// ValidateKeymap 检查 keymap 中的按键名是否都能识别，便于在启动前报错
+func ValidateKeymap(keymap map[string]string) error {
+	if _, err := formatModes(keymap); err != nil {
+		return err
+	}
+	return validateActions(keymap)
+}
+
+// ValidateBinding 检查一部分绑定的按键名和 action 参数。与 ValidateKeymap 不同，
+// 不检查 mode(name) 的模式是否存在，模式可能由其他绑定定义
+func ValidateBinding(keymap map[string]string) error {
+	for _, keys := range splitModes(keymap) {
+		if _, err := formatKeymap(keys); err != nil {
+			return err
+		}
+	}
+	return validateActions(keymap)
+}
+
+// 检查 action 的写法和参数，例如括号是否完整、signal 的信号名是否存在
+func validateActions(keymap map[string]string) error {
+	var keys []string
+	for k := range keymap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _ := splitDescription(keymap[k])
+		for _, s := range splitTopLevel(v, '+') {
+			if _, err := parseAction(s); err != nil {
+				return fmt.Errorf("invalid action in binding of %s: %w", k, err)
+			}
+		}
+		for _, action := range parseActions(keymap[k]) {
+			if action.Type != ActionTypeSignal {
+				continue
+			}
+			if _, ok := ParseSignal(action.Arg); !ok {
+				return fmt.Errorf("unknown signal in binding of %s: %q", k, action.Arg)
+			}
+		}
+	}
+	return nil
+}
+
+var signals = map[string]syscall.Signal{
+	"TERM":  syscall.SIGTERM,
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"TSTP":  syscall.SIGTSTP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal 解析 TERM、SIGTERM、usr1 这样的信号名
+func ParseSignal(name string) (syscall.Signal, bool) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	return sig, ok
+}
+
+// 所有可用的按键写法，用于错误提示
+func keyNames() []string {
+	names := []string{"<char>", "<chars> (sequence)", "ctrl-<char>", "ctrl-shift-<letter>", "shift-<letter>", "alt-<char>", "enter", "tab", "raw:/<regexp>/"}
+	var named []string
+	for k := range keySequences {
+		named = append(named, k)
+	}
+	sort.Strings(named)
+	return append(names, named...)
+}
+
+// 不是已知按键名的多个可打印字符，按顺序依次按下时触发。
+// 含 - 或 _、f13 以及 hoem 这样与按键名只差一处的更像是写错的按键名，不当作连按
+func isChord(k string) bool {
+	if len(k) < 2 || strings.ContainsAny(k, "-_") || fKeyName.MatchString(k) || similarKey(k) != "" {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+var fKeyName = regexp.MustCompile(`^[fF][0-9]+$`)
+
+// 返回与 k 只差一个字符 (增、删、改或相邻交换) 的按键名，没有时返回空字符串。
+// 两个字符的连按 (gg、zz) 太容易与 up 这样的短按键名相近，不检查
+func similarKey(k string) string {
+	if len(k) < 3 {
+		return ""
+	}
+	k = strings.ToLower(k)
+	names := []string{"enter", "tab"}
+	for name := range keySequences {
+		names = append(names, name)
+	}
+	for name := range keyAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name != k && editDistance(k, name) <= 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// 编辑距离，相邻两个字符交换算一次编辑 (optimal string alignment)
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// 连按的两个按键之间最多间隔这么久，超时后已按下的字符原样转发给子进程
+const chordTimeout = 500 * time.Millisecond
+
+// raw:/<regexp>/ 形式的按键，用正则匹配收到的原始字节
+type rawBinding struct {
+	re      *regexp.Regexp
+	actions []Action
+}
+
+func isRawKey(k string) bool {
+	return strings.HasPrefix(k, "raw:/") && strings.HasSuffix(k, "/") && len(k) > 6
+}
+
+// 收集 raw 按键，正则需要匹配完整的输入。按正则排序，保证多个正则都能匹配时结果稳定
+func rawKeymap(keymap map[string]string) []rawBinding {
+	var keys []string
+	for k := range keymap {
+		if isRawKey(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var bindings []rawBinding
+	for _, k := range keys {
+		re, err := regexp.Compile(`^(?:` + k[5:len(k)-1] + `)$`)
+		if err != nil {
+			log.Printf("Invalid raw key %s: %v\n", k, err)
+			continue
+		}
+		actions := parseActions(keymap[k])
+		for i := range actions {
+			actions[i].Key = k
+		}
+		bindings = append(bindings, rawBinding{re, actions})
+	}
+	return bindings
+}
+
+// CSI u 编码中的修饰键，序列里的值为 1 加上各修饰键之和
+const (
+	modShift = 1
+	modAlt   = 2
+	modCtrl  = 4
+)
+
+// 解析 ctrl-、alt-、shift- 任意组合修饰的单个字符，例如 ctrl-alt-e，返回终端可能发送的所有序列。
+// 不是这种形式时返回 nil
+func modifiedKey(k string) []string {
+	mods := 0
+	prefixes := []struct {
+		prefix string
+		mod    int
+	}{{"ctrl-", modCtrl}, {"alt-", modAlt}, {"shift-", modShift}}
+parse:
+	for {
+		for _, p := range prefixes {
+			if strings.HasPrefix(k, p.prefix) && mods&p.mod == 0 && len(k) > len(p.prefix) {
+				mods |= p.mod
+				k = k[len(p.prefix):]
+				continue parse
+			}
+		}
+		break
+	}
+	if mods == 0 || len(k) != 1 {
+		return nil
+	}
+
+	code := k[0]
+	// 传统编码：ctrl 转成 C0 控制字符，shift 转成大写，alt 加 ESC 前缀
+	legacy := ""
+	switch {
+	case mods&modCtrl != 0 && mods&modShift != 0:
+		// 无法区分 ctrl-shift-a 和 ctrl-a，只匹配 CSI u
+	case mods&modCtrl != 0:
+		// 终端对 ctrl-A 和 ctrl-a 发送相同的字节，统一按小写处理
+		if code >= 'A' && code <= 'Z' {
+			code += 'a' - 'A'
+		}
+		switch {
+		case code >= 'a' && code <= 'z':
+			legacy = string(code - 'a' + 1)
+		case code == '@' || (code >= '[' && code <= '_'):
+			// ctrl-@ 为 NUL，ctrl-[ ctrl-\ ctrl-] ctrl-^ ctrl-_ 依次为 0x1b..0x1f
+			legacy = string(code - '@')
+		}
+	case mods&modShift != 0:
+		// 只有字母有确定的 shift 形式，shift-1 这样的写法取决于键盘布局，当作无法识别的按键
+		if !(code >= 'a' && code <= 'z' || code >= 'A' && code <= 'Z') {
+			return nil
+		}
+		legacy = strings.ToUpper(k)
+	default:
+		legacy = k
+	}
+	if legacy != "" && mods&modAlt != 0 {
+		legacy = "\x1b" + legacy // ESC 前缀
+	}
+
+	var seqs []string
+	if legacy != "" {
+		seqs = append(seqs, legacy)
+	}
+	// 只有一个 shift 或 alt 时终端总是发送传统编码，其余组合还可能以 CSI u 发送
+	if mods != modShift && mods != modAlt {
+		if code >= 'A' && code <= 'Z' && mods&modCtrl == 0 {
+			mods |= modShift
+		}
+		seqs = append(seqs, csiU(code, mods))
+	}
+	return seqs
+}
+
+// 按 CSI u (fixterms/kitty) 编码按键，字母使用小写的码点
+func csiU(code byte, mods int) string {
+	if code >= 'A' && code <= 'Z' {
+		code += 'a' - 'A'
+	}
+	return fmt.Sprintf("\x1b[%d;%du", code, 1+mods)
+}
+
+// PrintKeymap 按按键名排序，逐行输出每个按键匹配的字节序列和 action。
+// 存在无法识别的按键或写错的 action 时返回 false
+func PrintKeymap(w io.Writer, keymap map[string]string) bool {
+	var keys []string
+	for k := range keymap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ok := true
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSEQUENCE\tACTION")
+	for _, k := range keys {
+		var actions []string
+		for _, a := range parseActions(keymap[k]) {
+			actions = append(actions, a.String())
+		}
+		action := strings.Join(actions, "+")
+		if err := validateActions(map[string]string{k: keymap[k]}); err != nil {
+			action = "(invalid action: " + keymap[k] + ")"
+			ok = false
+		}
+		_, key := splitModeKey(k)
+		if isRawKey(key) {
+			fmt.Fprintf(tw, "%s\t/%s/\t%s\n", k, key[5:len(key)-1], action)
+			continue
+		}
+		m, err := formatKeymap(map[string]string{key: keymap[k]})
+		if err != nil {
+			fmt.Fprintf(tw, "%s\t(unknown key)\t%s\n", k, action)
+			ok = false
+		}
+		var seqs []string
+		for seq := range m {
+			seqs = append(seqs, seq)
+		}
+		sort.Strings(seqs)
+		for _, seq := range seqs {
+			fmt.Fprintf(tw, "%s\t%q\t%s\n", k, seq, action)
+		}
+	}
+	tw.Flush()
+	return ok
+}
+
+// 返回所有多字节序列的真前缀。
+// 绑定了 esc 时，单独的 ESC 也可能是其他转义序列 (方向键、alt 组合等) 的第一个字节，同样需要等待
+func sequencePrefixes(keymap map[string][]Action) map[string]bool {
+	prefixes := make(map[string]bool)
+	for seq := range keymap {
+		for i := 1; i < len(seq); i++ {
+			prefixes[seq[:i]] = true
+		}
+	}
+	if _, ok := keymap["\x1b"]; ok {
+		prefixes["\x1b"] = true
+	}
+	return prefixes
+}
+
+// keymap 中是否有 t 类型的 action
+func hasAction(keymap map[string]string, t ActionType) bool {
+	for _, v := range keymap {
+		for _, action := range parseActions(v) {
+			if action.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 按顶层的 + 拆分多个 action，括号内的 + 属于参数，例如 execute(a+b)
+func parseActions(v string) []Action {
+	v, desc := splitDescription(v)
+	var actions []Action
+	for _, s := range splitTopLevel(v, '+') {
+		action, _ := parseAction(s)
+		action.Desc = desc
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+// 括号外的第一个冒号之后是绑定的说明，例如 become(nvim):edit in nvim
+func splitDescription(v string) (string, string) {
+	parts := splitTopLevel(v, ':')
+	if len(parts) == 1 {
+		return v, ""
+	}
+	return parts[0], strings.TrimSpace(strings.Join(parts[1:], ":"))
+}
+
+// 按括号外的 sep 拆分字符串
+func splitTopLevel(v string, sep rune) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range v {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, v[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, v[start:])
+}
+
+func parseAction(v string) (Action, error) {
+	var action Action
+	v = strings.TrimSpace(v)
+	// name(arg) 形式先检查括号，缺少右括号或括号不配对时报错，不能直接按位置截取参数
+	name, arg, hasArg := strings.Cut(v, "(")
+	if hasArg {
+		if !strings.HasSuffix(arg, ")") {
+			return action, fmt.Errorf("missing ) in %q", v)
+		}
+		arg = arg[:len(arg)-1]
+		if !balancedParens(arg) {
+			return action, fmt.Errorf("unbalanced parentheses in %q", v)
+		}
+	}
+	if v == "exit" {
+		action = Action{
+			Type: ActionTypeExit,
+		}
+	} else if v == "abort" {
+		action = Action{
+			Type: ActionTypeAbort,
+		}
+	} else if v == "refresh" || v == "clear-screen" {
+		action = Action{
+			Type: ActionTypeRefresh,
+		}
+	} else if v == "pause" {
+		action = Action{
+			Type: ActionTypePause,
+		}
+	} else if v == "resume" {
+		action = Action{
+			Type: ActionTypeResume,
+		}
+	} else if v == "help" {
+		action = Action{
+			Type: ActionTypeHelp,
+		}
+	} else if v == "reload" {
+		action = Action{
+			Type: ActionTypeReload,
+		}
+	} else if hasArg && name == "become" {
+		action = Action{
+			Type: ActionTypeBecome,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "become-tty" {
+		action = Action{
+			Type: ActionTypeBecomeTty,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "become-stdin" {
+		action = Action{
+			Type: ActionTypeBecomeStdin,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "execute" {
+		action = Action{
+			Type: ActionTypeExecute,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "execute-async" {
+		action = Action{
+			Type: ActionTypeExecuteAsync,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "execute-raw" {
+		action = Action{
+			Type: ActionTypeExecuteRaw,
+			Arg:  arg,
+		}
+		var err error
+		if action.Args, err = SplitArgs(action.Arg); err != nil {
+			return action, fmt.Errorf("execute-raw: %w", err)
+		}
+		if len(action.Args) == 0 {
+			return action, fmt.Errorf("missing command in %q", v)
+		}
+	} else if hasArg && name == "execute-silent" {
+		action = Action{
+			Type: ActionTypeExecuteSilent,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "put" {
+		action = Action{
+			Type: ActionTypeSend,
+			Arg:  unescape(arg),
+		}
+	} else if hasArg && name == "send-keys" {
+		action = Action{
+			Type: ActionTypeSend,
+			Arg:  unescape(arg),
+		}
+	} else if hasArg && name == "send-stdin" {
+		action = Action{
+			Type: ActionTypeSendStdin,
+			Arg:  unescape(arg),
+		}
+	} else if hasArg && name == "copy" {
+		action = Action{
+			Type: ActionTypeCopy,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "screenshot" {
+		action = Action{
+			Type: ActionTypeScreenshot,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "edit" {
+		action = Action{
+			Type: ActionTypeEdit,
+			Arg:  arg,
+		}
+	} else if hasArg && name == "mode" {
+		action = Action{
+			Type: ActionTypeMode,
+			Arg:  strings.TrimSpace(arg),
+		}
+	} else if hasArg && name == "signal" {
+		action = Action{
+			Type: ActionTypeSignal,
+			Arg:  strings.TrimSpace(arg),
+		}
+	} else if hasArg && name == "toggle" {
+		action = Action{
+			Type: ActionTypeToggle,
+			Arg:  arg,
+		}
+		for _, cmd := range splitTopLevel(action.Arg, ',') {
+			action.Args = append(action.Args, strings.TrimSpace(cmd))
+		}
+	} else if hasArg && name == "print" {
+		action = Action{
+			Type: ActionTypePrint,
+			Arg:  arg,
+		}
+	} else if v == "" {
+		return action, errors.New("missing action")
+	} else {
+		return action, fmt.Errorf("unknown action %q", v)
+	}
+	return action, nil
+}
+
+// 括号是否配对，参数中的括号会影响按 + 和 : 拆分
+func balancedParens(s string) bool {
+	depth := 0
+	for _, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// 解析 put/send-keys 参数中的 \n、\r、\t、\e 和 \\，其他反斜杠原样保留
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'e':
+			b.WriteByte('\x1b')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// SplitArgs 按空白拆分命令行，支持单引号、双引号和反斜杠转义，不做变量展开等其他 shell 语法
+func SplitArgs(s string) ([]string, error) {
+	var args []string
+	var b strings.Builder
+	inArg := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '\\' && i < len(s)-1 && (quote == 0 || strings.IndexByte(`"\$`+"`", s[i+1]) >= 0):
+			i++
+			b.WriteByte(s[i])
+			inArg = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// SGR 鼠标事件: ESC [ < button ; x ; y M (按下) 或 m (松开)
+var sgrMouse = regexp.MustCompile(`^\x1b\[<(\d+);\d+;\d+([Mm])$`)
+
+// 去掉鼠标事件中的坐标，便于和 keySequences 中的鼠标按键匹配
+func normalizeMouse(seq string) string {
+	match := sgrMouse.FindStringSubmatch(seq)
+	if match == nil {
+		return seq
+	}
+	return "\x1b[<" + match[1] + match[2]
+}
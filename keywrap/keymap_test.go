@@ -0,0 +1,316 @@
+package keywrap
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFormatKeymap(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string // 按字典序排列的字节序列
+	}{
+		{"q", []string{"q"}},
+		{"Q", []string{"Q"}},
+		{",", []string{","}},
+		{"ctrl-e", []string{"\x05", "\x1b[101;5u"}},
+		{"ctrl-E", []string{"\x05", "\x1b[101;5u"}},
+		{"ctrl-[", []string{"\x1b", "\x1b[91;5u"}},
+		{"ctrl-@", []string{"\x00", "\x1b[64;5u"}},
+		{"ctrl-1", []string{"\x1b[49;5u"}},
+		{"ctrl-shift-a", []string{"\x1b[97;6u"}},
+		{"shift-k", []string{"K"}},
+		{"alt-e", []string{"\x1be"}},
+		{"alt-E", []string{"\x1bE"}},
+		{"ctrl-shift-e", []string{"\x1b[101;6u"}},
+		{"ctrl-alt-e", []string{"\x1b\x05", "\x1b[101;7u"}},
+		{"alt-ctrl-e", []string{"\x1b\x05", "\x1b[101;7u"}},
+		{"alt-shift-e", []string{"\x1bE", "\x1b[101;4u"}},
+		{"ctrl-alt-shift-e", []string{"\x1b[101;8u"}},
+		{"ctrl--", []string{"\x1b[45;5u"}},
+		{"enter", []string{"\n"}},
+		{"tab", []string{"\t"}},
+		{"space", []string{" "}},
+		{"up", []string{"\x1bOA", "\x1b[A"}},
+		{"pgdn", []string{"\x1b[6~"}},
+		{"backspace", []string{"\x7f"}},
+		{"del", []string{"\x1b[3~"}},
+		{"gg", []string{"gg"}},
+		{"GG", []string{"GG"}},
+		{"ciw", []string{"ciw"}},
+		{"Ctrl-E", []string{"\x05", "\x1b[101;5u"}},
+		{"CTRL-e", []string{"\x05", "\x1b[101;5u"}},
+		{"Alt-E", []string{"\x1bE"}},
+		{"meta-e", []string{"\x1be"}},
+		{"Enter", []string{"\n"}},
+		{"RETURN", []string{"\n"}},
+		{"Escape", []string{"\x1b"}},
+		{"Shift-Tab", []string{"\x1b[Z"}},
+		{"ins", []string{"\x1b[2~"}},
+		{"prior", []string{"\x1b[5~"}},
+		{"F5", []string{"\x1b[15~", "\x1b[[E"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			m, err := formatKeymap(map[string]string{tt.key: "exit"})
+			if err != nil {
+				t.Fatalf("formatKeymap(%q) error: %v", tt.key, err)
+			}
+			var got []string
+			for seq, actions := range m {
+				got = append(got, seq)
+				want := []Action{{Type: ActionTypeExit, Key: tt.key}}
+				if !reflect.DeepEqual(actions, want) {
+					t.Errorf("actions for %q = %v, want %v", seq, actions, want)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("formatKeymap(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatKeymapUnknownKey(t *testing.T) {
+	tests := []struct {
+		keys    []string
+		wantErr string
+	}{
+		{[]string{"ctrl_e"}, "unknown key: ctrl_e"},
+		{[]string{"f13"}, "unknown key: f13"},
+		{[]string{"ctrl-"}, "unknown key: ctrl-"},
+		{[]string{"q", "zz-top", "alt-"}, "unknown key: alt-, zz-top"},
+		{[]string{"shift-1"}, "unknown key: shift-1"},
+		{[]string{"shift-;"}, "unknown key: shift-;"},
+		{[]string{"alt-shift-1"}, "unknown key: alt-shift-1"},
+		{[]string{"hoem"}, "unknown key: hoem (did you mean home?)"},
+		{[]string{"entre"}, "unknown key: entre (did you mean enter?)"},
+		{[]string{"pgpu"}, "unknown key: pgpu (did you mean pgup?)"},
+		{[]string{"Tabb"}, "unknown key: Tabb (did you mean tab?)"},
+	}
+	for _, tt := range tests {
+		keymap := make(map[string]string)
+		for _, k := range tt.keys {
+			keymap[k] = "exit"
+		}
+		_, err := formatKeymap(keymap)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr+"\n") {
+			t.Errorf("formatKeymap(%q) error = %v, want %q", tt.keys, err, tt.wantErr)
+		}
+	}
+}
+
+func TestKeymapConflicts(t *testing.T) {
+	tests := []struct {
+		keys []string
+		want []string
+	}{
+		{[]string{"q", "ctrl-e"}, nil},
+		{[]string{"tab", "ctrl-i"}, []string{`ctrl-i and tab both match "\t"`}},
+		{[]string{"esc", "ctrl-["}, []string{`ctrl-[ and esc both match "\x1b"`}},
+		{[]string{"bspace", "backspace"}, []string{`backspace and bspace both match "\x7f"`}},
+	}
+	for _, tt := range tests {
+		keymap := make(map[string]string)
+		for _, k := range tt.keys {
+			keymap[k] = "exit"
+		}
+		if got := keymapConflicts(keymap); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keymapConflicts(%q) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestFormatModes(t *testing.T) {
+	modes, err := formatModes(map[string]string{
+		"q":        "exit",
+		"ctrl-w":   "mode(normal)",
+		"normal:q": "abort",
+		"normal:w": "mode(default)",
+	})
+	if err != nil {
+		t.Fatalf("formatModes: %v", err)
+	}
+	if got := modes[DefaultMode].keys["q"][0].Type; got != ActionTypeExit {
+		t.Errorf("default q = %s, want %s", got, ActionTypeExit)
+	}
+	if got := modes["normal"].keys["q"][0].Type; got != ActionTypeAbort {
+		t.Errorf("normal q = %s, want %s", got, ActionTypeAbort)
+	}
+	if _, ok := modes["normal"].keys["\x17"]; ok {
+		t.Errorf("normal mode has ctrl-w of default mode")
+	}
+	if _, err := formatModes(map[string]string{"q": "mode(insert)"}); err == nil {
+		t.Errorf("formatModes with unknown mode = nil, want error")
+	}
+	// 不同模式中的相同按键不算冲突
+	if got := keymapConflicts(map[string]string{"q": "exit", "normal:q": "abort"}); got != nil {
+		t.Errorf("keymapConflicts = %q, want nil", got)
+	}
+}
+
+func TestBreaksChord(t *testing.T) {
+	modes, err := formatModes(map[string]string{
+		"gg":        "exit",
+		"gqq":       "abort",
+		"q":         "exit",
+		"esc":       "abort",
+		"raw:/^gx/": "exit",
+	})
+	if err != nil {
+		t.Fatalf("formatModes: %v", err)
+	}
+	km := modes[DefaultMode]
+	tests := []struct {
+		held, more string
+		want       bool
+	}{
+		{"g", "g", false},
+		{"g", "q", false},
+		{"gq", "w", true},
+		{"g", "w", true},
+		{"g", "\x1b[A", true},
+		{"g", "x", false},
+		{"\x1b", "[A", false},
+	}
+	for _, tt := range tests {
+		if got := km.breaksChord([]byte(tt.held), []byte(tt.more)); got != tt.want {
+			t.Errorf("breaksChord(%q, %q) = %v, want %v", tt.held, tt.more, got, tt.want)
+		}
+	}
+}
+
+func TestIsModeBind(t *testing.T) {
+	tests := []struct {
+		bind string
+		want bool
+	}{
+		{"normal:ctrl-e:become(nvim)", true},
+		{"normal:q:exit:quit", true},
+		{"q:exit", false},
+		{"ctrl-e:become(nvim):edit in nvim", false},
+		{"x:put(a:b)", false},
+		{"raw:/a:b/:exit", false},
+		{"q:become(;w:exit", false},
+		{"normal:(:exit", true},
+	}
+	for _, tt := range tests {
+		if got := IsModeBind(tt.bind); got != tt.want {
+			t.Errorf("IsModeBind(%q) = %v, want %v", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestValidateBinding(t *testing.T) {
+	for _, keymap := range []map[string]string{
+		{"q": "exit"},
+		{"normal:q": "abort"},
+		{"m": "mode(normal)"}, // 模式可能由其他绑定定义
+	} {
+		if err := ValidateBinding(keymap); err != nil {
+			t.Errorf("ValidateBinding(%q) = %v, want nil", keymap, err)
+		}
+	}
+	for _, keymap := range []map[string]string{
+		{"ctrl_e": "exit"},
+		{"normal:ctrl_e": "exit"},
+		{"q": "signal(FOO)"},
+	} {
+		if err := ValidateBinding(keymap); err == nil {
+			t.Errorf("ValidateBinding(%q) = nil, want error", keymap)
+		}
+	}
+}
+
+func TestValidateKeymapActions(t *testing.T) {
+	for _, v := range []string{"become(nvim)", "execute(echo (a))+reload", "print(a):desc (b)", "toggle(a,b)"} {
+		if err := ValidateKeymap(map[string]string{"q": v}); err != nil {
+			t.Errorf("ValidateKeymap(%q) = %v, want nil", v, err)
+		}
+	}
+	tests := []struct {
+		v       string
+		wantErr string
+	}{
+		{"become(", `invalid action in binding of q: missing ) in "become("`},
+		{"become(nvim", `invalid action in binding of q: missing ) in "become(nvim"`},
+		{"exit+execute(a", `invalid action in binding of q: missing ) in "execute(a"`},
+		{"print(a))", `invalid action in binding of q: unbalanced parentheses in "print(a))"`},
+		{"print(a)b)", `invalid action in binding of q: unbalanced parentheses in "print(a)b)"`},
+		{`execute-raw(echo "a)`, `invalid action in binding of q: execute-raw: unterminated quote in "echo \"a"`},
+		{"execute-raw( )", `invalid action in binding of q: missing command in "execute-raw( )"`},
+		{"exitt", `invalid action in binding of q: unknown action "exitt"`},
+		{"bogus(1)", `invalid action in binding of q: unknown action "bogus(1)"`},
+		{"exit+", `invalid action in binding of q: missing action`},
+		{"", `invalid action in binding of q: missing action`},
+	}
+	for _, tt := range tests {
+		err := ValidateKeymap(map[string]string{"q": tt.v})
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("ValidateKeymap(%q) error = %v, want %q", tt.v, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateKeymapSignal(t *testing.T) {
+	for _, v := range []string{"signal(USR1)", "signal(SIGHUP)", "signal( term )", "exit+signal(winch)"} {
+		if err := ValidateKeymap(map[string]string{"q": v}); err != nil {
+			t.Errorf("ValidateKeymap(%q) = %v, want nil", v, err)
+		}
+	}
+	for _, v := range []string{"signal(FOO)", "signal()", "exit+signal(SIGSIG)"} {
+		if err := ValidateKeymap(map[string]string{"q": v}); err == nil {
+			t.Errorf("ValidateKeymap(%q) = nil, want error", v)
+		}
+	}
+}
+
+func TestParseActionsDescription(t *testing.T) {
+	tests := []struct {
+		v    string
+		want []Action
+	}{
+		{"exit", []Action{{Type: ActionTypeExit}}},
+		{"become(nvim):edit in nvim", []Action{{Type: ActionTypeBecome, Arg: "nvim", Desc: "edit in nvim"}}},
+		{"print(a:b)", []Action{{Type: ActionTypePrint, Arg: "a:b"}}},
+		{"print(a)+exit: quit: now", []Action{
+			{Type: ActionTypePrint, Arg: "a", Desc: "quit: now"},
+			{Type: ActionTypeExit, Desc: "quit: now"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := parseActions(tt.v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseActions(%q) = %+v, want %+v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"echo a  b", []string{"echo", "a", "b"}},
+		{`echo 'a b' "c d"`, []string{"echo", "a b", "c d"}},
+		{`echo a\ b`, []string{"echo", "a b"}},
+		{`echo "a \"b\" \n"`, []string{"echo", `a "b" \n`}},
+		{`echo 'it''s' ""`, []string{"echo", "its", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.in)
+		if err != nil {
+			t.Errorf("SplitArgs(%q) error: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if _, err := SplitArgs(`echo "a`); err == nil {
+		t.Errorf("SplitArgs with unterminated quote: want error")
+	}
+}
@@ -0,0 +1,71 @@
+package keywrap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ShowKeys 把 tty 设为原始模式，逐个输出收到的按键名、十六进制和原始字节，按 Ctrl-C 结束。
+// 用来查看当前终端的按键应该怎么写在 --bind 中
+func ShowKeys(tty *os.File, w io.Writer) error {
+	oldState, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(tty.Fd()), oldState)
+
+	names := keyNamesBySequence()
+	fmt.Fprint(w, "Press keys to see their names, Ctrl-C to quit\r\n")
+	buf := make([]byte, 1024)
+	for {
+		n, err := readRetry(tty, buf)
+		if err != nil {
+			return err
+		}
+		received := string(buf[:n])
+		name := strings.Join(names[received], " / ")
+		if name == "" {
+			name = "(unknown)"
+		}
+		fmt.Fprintf(w, "%-20s %-20s %q\r\n", name, hex.EncodeToString(buf[:n]), received)
+		if received == "\x03" {
+			return nil
+		}
+	}
+}
+
+// 反查每个字节序列可以用哪些按键名绑定，与 formatKeymap 使用同样的规则
+func keyNamesBySequence() map[string][]string {
+	names := make(map[string][]string)
+	add := func(name string, seqs []string) {
+		for _, seq := range seqs {
+			names[seq] = append(names[seq], name)
+		}
+	}
+	for name, seqs := range keySequences {
+		add(name, seqs)
+	}
+	add("enter", []string{"\n"})
+	add("tab", []string{"\t"})
+	for c := byte('!'); c <= '~'; c++ {
+		add(string(c), []string{string(c)})
+		// 大写字母的 ctrl 组合与小写相同，alt-shift 与 alt 加大写字母相同，跳过重复的写法
+		prefixes := []string{"alt-"}
+		if c < 'A' || c > 'Z' {
+			prefixes = append(prefixes, "ctrl-", "ctrl-alt-", "ctrl-shift-", "ctrl-alt-shift-")
+		}
+		for _, p := range prefixes {
+			add(p+string(c), modifiedKey(p+string(c)))
+		}
+	}
+	for _, list := range names {
+		sort.Strings(list)
+	}
+	return names
+}
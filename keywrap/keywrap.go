@@ -0,0 +1,1515 @@
+// Package keywrap 在伪终端 (PTY) 中运行命令，并按 keymap 拦截按键触发 action。
+package keywrap
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// ErrCommandNotFound 表示要运行的命令不存在，Run 此时返回 127
+var ErrCommandNotFound = errors.New("command not found")
+
+// ErrNoTerminal 表示没有可以读取按键的终端 (例如在 cron 中运行)，Run 此时返回 3
+var ErrNoTerminal = errors.New("requires a controlling terminal")
+
+// ErrBecome 表示 become 无法替换当前进程 (例如 Shell 不存在)，Run 此时返回 5。
+// 命令本身由 Shell 执行，找不到时由 Shell 报错并返回 127
+var ErrBecome = errors.New("cannot become")
+
+// OpenTTY 打开控制终端。没有控制终端但 stdin 是终端时使用 stdin，
+// 返回的 close 只关闭自己打开的文件
+func OpenTTY() (tty *os.File, close func() error, err error) {
+	tty, err = os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err == nil {
+		return tty, tty.Close, nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return os.Stdin, func() error { return nil }, nil
+	}
+	return nil, nil, fmt.Errorf("%w (%v)", ErrNoTerminal, err)
+}
+
+// Config 描述一次 Run 的全部配置
+type Config struct {
+	Cmd         []string
+	Fallbacks   [][]string    // Cmd 启动失败或很快以非 0 退出时，依次尝试的备选命令
+	Retry       int           // 启动失败或很快以非 0 退出时，等待 RetryDelay 后重新启动同一个命令的次数
+	RetryDelay  time.Duration // 每次重试前等待的时间，为 0 时使用 1s
+	Keymap      map[string]string
+	Hold        bool
+	HoldMessage string // 子进程退出后 Hold 状态下的提示，为空时使用默认提示
+	Input       string
+	InputFile   string
+	InputDelay  time.Duration // 启动后等待这么久再写入 Input 和 InputFile
+	EchoInput   bool          // 逐个字符写入 Input 并同时输出到 Stdout，用于演示
+	Shell       string        // 执行 become/execute 等用户命令的 shell，为空时依次使用 $SHELL、/bin/sh
+	Cwd         string
+	Env         []string
+	Term        string         // 不为空时作为子进程的 TERM
+	ColorTerm   string         // 不为空时作为子进程的 COLORTERM
+	Path        []string       // 加到 PATH 前面的目录，对子进程和 become/execute 命令都生效
+	BufferSize  int            // 读取 tty 和 pty 的缓冲区大小，为 0 时使用 32KiB，最小 1024
+	KeyLog      io.Writer      // 不为空时，每次按键以一行 JSON 写入
+	Timeout     time.Duration  // 超过这么久没有按键就退出，为 0 时不限制
+	ExecTimeout time.Duration  // execute 类命令运行超过这么久就杀掉，为 0 时不限制
+	Scrollback  int            // 保留最近多少字节的输出，reload 后先重放给新进程，为 0 时不保留
+	MaxOutput   int64          // 子进程累计输出超过这么多字节时停止子进程，Run 返回 4；为 0 时不限制
+	Title       string         // 终端窗口标题，退出时恢复原标题
+	Header      string         // 固定显示在终端第一行的文字，子进程使用剩下的行
+	Mouse       bool           // 开启鼠标上报 (SGR 模式)，可以绑定 left-click 等鼠标事件
+	AltScreen   bool           // 在备用屏幕中运行，退出后恢复原来的屏幕内容
+	OnExit      string         // 退出前通过 Shell 执行的命令，输出被丢弃
+	Clipboard   string         // copy action 设置剪贴板的方式: auto (默认)、osc52 或从 stdin 读取内容的 shell 命令
+	Watch       []string       // 这些文件变化时 reload 子进程
+	Passthrough []string       // 这些按键总是转发给子进程，即使在 Keymap 中有绑定
+	KillSignal  syscall.Signal // 停止子进程时先发送的信号，为 0 时使用 SIGTERM
+	KillTimeout time.Duration  // 发送 KillSignal 后等待多久再 SIGKILL，为 0 时使用 2 秒，负数表示直接 SIGKILL
+	Quiet       bool           // 不输出运行中的诊断信息 (子进程退出码、resize 失败等)
+	OutputLog   io.Writer      // 不为空时，子进程的输出同时写入这里
+	OutputPlain bool           // 写入 OutputLog 前去掉 ANSI 转义序列
+	SplitStderr bool           // 子进程的 stderr 直接写到 keywrap 的 stderr，不经过 pty
+	Record      io.Writer      // 不为空时，以 asciinema v2 (.cast) 格式录制子进程的输出
+	Once        bool           // 执行完第一次触发的 action 后退出，未绑定的按键不再转发
+	NoForward   bool           // 未绑定的按键直接丢弃，不转发给子进程；Passthrough 中的按键仍然转发
+	Strict      bool           // 两个按键解析成同一个字节序列时报错，而不只是警告
+	Cols        int            // 不为 0 时固定 pty 的列数，负数表示比终端少多少列
+	Rows        int            // 同 Cols，对应行数
+	NoStdin     bool           // 即使有 reload 等 action 也不把 stdin 收集到临时文件，适合 tail -f 这类不会结束的输入
+
+	// 以下为空时分别使用 os.Stdin、os.Stdout 和 /dev/tty。
+	// Tty 由调用方传入时 Run 不会关闭它，返回时停止读取，之后的按键仍由调用方读取
+	Stdin  *os.File
+	Stdout io.Writer
+	Tty    *os.File
+
+	liveStdin *os.File // NoStdin 时作为子进程的 fd 3 传入
+}
+
+// 子进程以及 become/execute 命令使用的环境变量
+func (cfg Config) environ() []string {
+	env := append(os.Environ(), cfg.Env...)
+	if len(cfg.Path) > 0 {
+		path := os.Getenv("PATH")
+		for _, kv := range cfg.Env {
+			if v, ok := strings.CutPrefix(kv, "PATH="); ok {
+				path = v
+			}
+		}
+		// 同名变量以最后一个为准
+		env = append(env, "PATH="+strings.Join(append(cfg.pathDirs(), path), string(filepath.ListSeparator)))
+	}
+	return env
+}
+
+// Path 转成绝对路径，--cwd 不影响相对目录的含义
+func (cfg Config) pathDirs() []string {
+	var dirs []string
+	for _, dir := range cfg.Path {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// 与 exec.LookPath 相同，但先在 Path 中查找
+func (cfg Config) lookPath(file string) (string, error) {
+	if !strings.Contains(file, "/") {
+		for _, dir := range cfg.pathDirs() {
+			path := filepath.Join(dir, file)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+				return path, nil
+			}
+		}
+	}
+	return exec.LookPath(file)
+}
+
+// 按 lookPath 查找可执行文件，Args[0] 保持原样
+func (cfg Config) command(name string, args ...string) *exec.Cmd {
+	path := name
+	if p, err := cfg.lookPath(name); err == nil {
+		path = p
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Args[0] = name
+	return cmd
+}
+
+// reload、toggle、edit 和备选命令会重新启动子进程，需要再读一遍完整的 stdin；
+// become-stdin 的新进程从文件读取 stdin，action 引用 __stdin_file__ 时也需要文件。
+// 其余情况直接把 stdin 交给子进程，省去复制
+func needsStdinFile(cfg Config) bool {
+	if len(cfg.Fallbacks) > 0 || len(cfg.Watch) > 0 || cfg.Retry > 0 {
+		return true
+	}
+	for _, v := range cfg.Keymap {
+		if usesStdinFile(v) {
+			return true
+		}
+		for _, action := range parseActions(v) {
+			if action.Type == ActionTypeReload || action.Type == ActionTypeToggle || action.Type == ActionTypeEdit ||
+				action.Type == ActionTypeBecomeStdin {
+				return true
+			}
+			if action.Type == ActionTypePrint || action.Type == ActionTypeSend {
+				continue
+			}
+			if script, err := actionScript(action.Arg); err == nil && usesStdinFile(script) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 读取时被信号打断 (EINTR) 或暂时没有数据 (EAGAIN) 不算结束，重试直到读到数据或真正的错误。
+// EOF、fd 被关闭以及 pty 另一端关闭时的 EIO 都原样返回
+func readRetry(f *os.File, buf []byte) (int, error) {
+	for {
+		n, err := f.Read(buf)
+		if n > 0 || err == nil {
+			return n, err
+		}
+		switch {
+		case errors.Is(err, syscall.EINTR):
+		case errors.Is(err, syscall.EAGAIN):
+			time.Sleep(10 * time.Millisecond)
+		default:
+			return n, err
+		}
+	}
+}
+
+// 命令通过 __stdin_file__ 或 $KEYWRAP_STDIN_FILE 读取 stdin 的临时文件
+func usesStdinFile(s string) bool {
+	return strings.Contains(s, "__stdin_file__") || strings.Contains(s, "KEYWRAP_STDIN_FILE")
+}
+
+// become/execute 的参数以 @ 开头时，从文件中读取要执行的命令
+func actionScript(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "@") {
+		return arg, nil
+	}
+	content, err := os.ReadFile(arg[1:])
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func collectStdinToFile(stdin *os.File) (*os.File, error) {
+	if term.IsTerminal(int(stdin.Fd())) {
+		return nil, nil
+	}
+	stdinFile, err := os.CreateTemp("", "keywrap-stdin")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(stdinFile, stdin); err != nil {
+		stdinFile.Close()
+		os.Remove(stdinFile.Name())
+		return nil, err
+	}
+
+	return stdinFile, nil
+}
+
+func startPty(cmd []string, cfg Config) (*exec.Cmd, *os.File, error) {
+	child := cfg.command(cmd[0], cmd[1:]...)
+	child.Env = cfg.environ()
+	if cfg.Term != "" {
+		child.Env = append(child.Env, "TERM="+cfg.Term)
+	}
+	if cfg.ColorTerm != "" {
+		child.Env = append(child.Env, "COLORTERM="+cfg.ColorTerm)
+	}
+	child.Dir = cfg.Cwd
+	if cfg.liveStdin != nil {
+		child.ExtraFiles = []*os.File{cfg.liveStdin}
+	}
+	if cfg.SplitStderr {
+		child.Stderr = os.Stderr
+	}
+
+	ptmx, err := pty.Start(child)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrCommandNotFound, cmd[0])
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 有的程序启动后要过一会儿才开始读输入，InputDelay 推迟写入，避免输入丢失。
+	// EchoInput 时逐个字符写入 Input，同时显示在屏幕上，模拟打字的效果
+	if (cfg.InputDelay > 0 || cfg.EchoInput) && (cfg.Input != "" || cfg.InputFile != "") {
+		var f *os.File
+		if cfg.InputFile != "" {
+			if f, err = os.Open(cfg.InputFile); err != nil {
+				return nil, nil, err
+			}
+		}
+		go func() {
+			time.Sleep(cfg.InputDelay)
+			if cfg.EchoInput {
+				for _, r := range cfg.Input {
+					io.WriteString(ptmx, string(r))
+					if r == '\n' {
+						io.WriteString(cfg.Stdout, "\r") // 终端处于原始模式，换行需要回到行首
+					}
+					io.WriteString(cfg.Stdout, string(r))
+					time.Sleep(echoInputInterval)
+				}
+			} else {
+				io.WriteString(ptmx, cfg.Input)
+			}
+			if f != nil {
+				defer f.Close()
+				io.Copy(ptmx, f)
+			}
+		}()
+		return child, ptmx, nil
+	}
+
+	if cfg.Input != "" {
+		_, err = ptmx.Write([]byte(cfg.Input))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.InputFile != "" {
+		f, err := os.Open(cfg.InputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 文件可能很大，子进程读取前 pty 缓冲区会写满，所以在后台流式写入
+		go func() {
+			defer f.Close()
+			io.Copy(ptmx, f)
+		}()
+	}
+
+	return child, ptmx, nil
+}
+
+// EchoInput 时每个字符之间的间隔
+const echoInputInterval = 30 * time.Millisecond
+
+// 显示光标并清除文字属性。被包装的 TUI 可能隐藏了光标或留下了颜色，
+// become/execute 的命令和 reload 后的新进程应该从正常的终端状态开始
+const resetTerminal = "\x1b[0m\x1b[?25h"
+
+// Run 在 pty 中运行 cfg.Cmd 直到子进程退出或触发 exit 类 action，返回 keywrap 应使用的退出码。
+// become 成功时当前进程会被替换，不会返回。
+func Run(ctx context.Context, cfg Config) (int, error) {
+	if len(cfg.Cmd) == 0 {
+		return 2, errors.New("missing command to run")
+	}
+	for _, fb := range cfg.Fallbacks {
+		if len(fb) == 0 {
+			return 2, errors.New("empty fallback command")
+		}
+	}
+	modes, err := formatModes(cfg.Keymap)
+	if err != nil {
+		return 2, err
+	}
+	if err := validateActions(cfg.Keymap); err != nil {
+		return 2, err
+	}
+	// 冲突的绑定只有一个生效，Strict 时当作错误
+	conflicts := keymapConflicts(cfg.Keymap)
+	if cfg.Strict && len(conflicts) > 0 {
+		return 2, fmt.Errorf("conflicting bindings: %s", strings.Join(conflicts, "; "))
+	}
+	passthroughKeys := make(map[string]string)
+	for _, k := range cfg.Passthrough {
+		passthroughKeys[k] = ""
+	}
+	passthrough, err := formatKeymap(passthroughKeys)
+	if err != nil {
+		return 2, err
+	}
+	if cfg.Shell == "" {
+		cfg.Shell = os.Getenv("SHELL")
+	}
+	if cfg.Shell == "" {
+		cfg.Shell = "/bin/sh"
+	}
+	if cfg.Stdin == nil {
+		cfg.Stdin = os.Stdin
+	}
+	if cfg.Stdout == nil {
+		cfg.Stdout = os.Stdout
+	}
+	// header 占用终端的第一行，子进程的 pty 少一行
+	if cfg.Header != "" && cfg.Rows <= 0 {
+		cfg.Rows--
+	}
+	tty := cfg.Tty
+	if tty == nil {
+		var err error
+		var closeTTY func() error
+		tty, closeTTY, err = OpenTTY()
+		if errors.Is(err, ErrNoTerminal) {
+			return 3, err
+		}
+		if err != nil {
+			return 1, err
+		}
+		defer closeTTY()
+	}
+	stdout := cfg.Stdout
+	// 诊断信息走 logger，Quiet 时丢弃；错误仍然通过返回值交给调用方
+	logger := log.New(os.Stderr, "", 0)
+	if cfg.Quiet {
+		logger.SetOutput(io.Discard)
+	}
+	for _, c := range conflicts {
+		logger.Printf("Warning: conflicting bindings: %s\n", c)
+	}
+	bufferSize := cfg.BufferSize
+	if bufferSize == 0 {
+		bufferSize = 32 * 1024
+	} else if bufferSize < 1024 {
+		bufferSize = 1024
+	}
+
+	fallbacks := cfg.Fallbacks
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = time.Second
+	}
+	// 当前命令剩余的重试次数，换成备选命令时重新计数
+	retries := cfg.Retry
+
+	// stdin 是终端时没有临时文件，__stdin_file__ 替换成 /dev/null
+	stdinPath := os.DevNull
+	var stdinFile *os.File
+	if !cfg.NoStdin && needsStdinFile(cfg) {
+		var err error
+		stdinFile, err = collectStdinToFile(cfg.Stdin)
+		if err != nil {
+			return 1, err
+		}
+	} else if !term.IsTerminal(int(cfg.Stdin.Fd())) {
+		cfg.liveStdin = cfg.Stdin
+	}
+	if stdinFile != nil {
+		// 使用绝对路径，避免 --cwd 或 TMPDIR 为相对路径时找不到文件
+		stdinPath, _ = filepath.Abs(stdinFile.Name())
+		defer os.Remove(stdinFile.Name())
+		defer stdinFile.Close()
+	}
+	// 有 send-stdin 绑定时子进程的 stdin 换成管道：原来的 stdin 由 keywrap 写入管道，之后还可以继续追加
+	stdinPipe := hasAction(cfg.Keymap, ActionTypeSendStdin)
+	var stdinMu sync.Mutex
+	var childStdin *os.File
+	writeStdin := func(p []byte) error {
+		stdinMu.Lock()
+		defer stdinMu.Unlock()
+		if childStdin == nil {
+			return errors.New("stdin of the command is closed")
+		}
+		_, err := childStdin.Write(p)
+		return err
+	}
+	defer func() {
+		if childStdin != nil {
+			childStdin.Close()
+		}
+	}()
+	if stdinPipe && cfg.liveStdin != nil {
+		// 不会结束的输入只能读一次，转发给当前的子进程
+		go func() {
+			buf := make([]byte, bufferSize)
+			for {
+				n, err := readRetry(cfg.liveStdin, buf)
+				if n > 0 {
+					writeStdin(buf[:n])
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	spawn := func(cmd []string) (*exec.Cmd, *os.File, error) {
+		if !stdinPipe {
+			return startPty(cmd, cfg)
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		pipeCfg := cfg
+		pipeCfg.liveStdin = r
+		child, ptmx, err := startPty(cmd, pipeCfg)
+		r.Close()
+		if err != nil {
+			w.Close()
+			return nil, nil, err
+		}
+		stdinMu.Lock()
+		if childStdin != nil {
+			childStdin.Close()
+		}
+		childStdin = w
+		stdinMu.Unlock()
+		if stdinFile != nil {
+			if f, err := os.Open(stdinPath); err == nil {
+				go func() {
+					defer f.Close()
+					io.Copy(w, f)
+				}()
+			}
+		}
+		return child, ptmx, nil
+	}
+	wrapStdin := func(cmd []string) []string {
+		if cfg.liveStdin != nil || stdinPipe {
+			return append([]string{posixShell, "-c", `exec "$@" <&3 3<&-`, posixShell}, cmd...)
+		}
+		if stdinFile == nil {
+			return cmd
+		}
+		// reload 时会再次读取，所以临时文件在退出时才删除。
+		// 用 exec 替换掉 shell，子进程的 PID 就是命令自己的 PID
+		return append([]string{posixShell, "-c", `exec "$@" <"$0"`, stdinPath}, cmd...)
+	}
+	// 经过 wrapStdin 后由 shell 执行命令，找不到时只能拿到 127，所以先自己查一次 PATH
+	startCmd := func(cmd []string) ([]string, *exec.Cmd, *os.File, error) {
+		if !strings.Contains(cmd[0], "/") {
+			if _, err := cfg.lookPath(cmd[0]); errors.Is(err, exec.ErrNotFound) {
+				return cmd, nil, nil, fmt.Errorf("%w: %s", ErrCommandNotFound, cmd[0])
+			}
+		}
+		cmd = wrapStdin(cmd)
+		child, ptmx, err := spawn(cmd)
+		return cmd, child, ptmx, err
+	}
+
+	cmd := cfg.Cmd
+	childCmd, child, ptmx, err := startCmd(cmd)
+	for err != nil && (retries > 0 || len(fallbacks) > 0) {
+		if retries > 0 {
+			retries--
+			logger.Printf("Error starting %s: %v, retrying in %v\n", cmd[0], err, cfg.RetryDelay)
+			time.Sleep(cfg.RetryDelay)
+		} else {
+			logger.Printf("Error starting %s: %v, trying fallback\n", cmd[0], err)
+			cmd, fallbacks = fallbacks[0], fallbacks[1:]
+			retries = cfg.Retry
+		}
+		childCmd, child, ptmx, err = startCmd(cmd)
+	}
+	if err != nil {
+		return startErrorCode(err), err
+	}
+	defer func() { ptmx.Close() }()
+	startedAt := time.Now()
+
+	// become 在 exec 之前已经恢复了终端，exec 失败返回时 defer 不再重复恢复
+	terminalRestored := false
+	restoreTerminal := func(s string) {
+		if !terminalRestored {
+			io.WriteString(stdout, s)
+		}
+	}
+
+	// 先把原标题压栈 (xterm 的 CSI 22;0 t)，退出时出栈恢复
+	if cfg.Title != "" {
+		fmt.Fprintf(stdout, "\x1b[22;0t\x1b]0;%s\x07", cfg.Title)
+		defer restoreTerminal("\x1b[23;0t")
+	}
+
+	if cfg.Mouse {
+		io.WriteString(stdout, "\x1b[?1000h\x1b[?1006h")
+		defer restoreTerminal("\x1b[?1006l\x1b[?1000l")
+	}
+
+	// print action 的内容在恢复终端之后才输出，方便调用方脚本读取
+	var printed []string
+	defer func() {
+		for _, s := range printed {
+			fmt.Fprintln(stdout, s)
+		}
+	}()
+
+	// 在 printed 之后注册，先离开备用屏幕再输出 print 的内容
+	if cfg.AltScreen {
+		io.WriteString(stdout, "\x1b[?1049h")
+		defer restoreTerminal("\x1b[?1049l")
+	}
+
+	// header 固定在第一行：滚动区域从第二行开始，并开启 origin mode，子进程的光标定位相对于滚动区域。
+	// 保存/恢复光标时 origin mode 也会一起恢复，所以重绘不影响子进程
+	drawHeader := func() {}
+	if cfg.Header != "" {
+		drawHeader = func() {
+			_, height, err := term.GetSize(int(tty.Fd()))
+			if err != nil || height < 2 {
+				return
+			}
+			fmt.Fprintf(stdout, "\x1b7\x1b[2;%dr\x1b[?6l\x1b[1;1H\x1b[2K\x1b[?7l%s\x1b[?7h\x1b8", height, cfg.Header)
+		}
+		drawHeader()
+		io.WriteString(stdout, "\x1b[?6h")
+		defer restoreTerminal("\x1b[r\x1b[?6l")
+	}
+
+	// 设置终端为原始模式，以便直接读取按键
+	oldState, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return 1, err
+	}
+	defer term.Restore(int(tty.Fd()), oldState)
+
+	// 在恢复终端之前执行 OnExit，become 时在 exec 之前执行，保证只执行一次
+	onExitDone := false
+	runOnExit := func() {
+		if cfg.OnExit == "" || onExitDone {
+			return
+		}
+		onExitDone = true
+		cmd := exec.Command(cfg.Shell, "-c", cfg.OnExit)
+		cmd.Env = cfg.environ()
+		cmd.Stdout = io.Discard
+		cmd.Stderr = io.Discard
+		if err := cmd.Run(); err != nil {
+			logger.Printf("Error running on-exit command: %v\n", err)
+		}
+	}
+	defer runOnExit()
+
+	// 处理终端大小变化
+	sigWinchChan := make(chan os.Signal, 1)
+	signal.Notify(sigWinchChan, syscall.SIGWINCH)
+	defer signal.Stop(sigWinchChan)
+	sigWinchChan <- syscall.SIGWINCH // 初始调整大小
+
+	// SIGINT/SIGTERM 转发给子进程，由子进程决定如何处理，keywrap 随子进程退出并恢复终端
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	// 原始模式下 ^Z 不会产生 SIGTSTP，由按键协程转成挂起请求
+	sigTstpChan := make(chan os.Signal, 1)
+	signal.Notify(sigTstpChan, syscall.SIGTSTP)
+	defer signal.Stop(sigTstpChan)
+
+	// 其他协程 panic 时 Run 的 defer 不会执行，终端会停在原始模式。
+	// 通过 goSafe 启动的协程把 panic 交给主循环，由主循环正常返回并恢复终端
+	panicChan := make(chan error, 1)
+	goSafe := func(f func()) {
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					select {
+					case panicChan <- fmt.Errorf("panic: %v\n%s", r, debug.Stack()):
+					default:
+					}
+				}
+			}()
+			f()
+		}()
+	}
+
+	var childExitChan chan error
+	waitChild := func() {
+		childExitChan = make(chan error, 1)
+		child, exitChan := child, childExitChan
+		goSafe(func() {
+			defer close(exitChan)
+			exitChan <- child.Wait()
+		})
+	}
+	waitChild()
+
+	// 子进程已退出且处于 --hold 等待状态，此时任意按键都直接退出
+	var held atomic.Bool
+	// 帮助界面是否正在显示，由按键协程在下一次按键时关闭
+	var helpOpen atomic.Bool
+	helpDoneChan := make(chan struct{})
+	// edit 启动的编辑器正在运行，此时按键全部转发给编辑器
+	var editing atomic.Bool
+	// 当前模式的绑定，由 mode action 切换，按键协程每次按键时读取
+	var activeMode atomic.Pointer[modeKeymap]
+	activeMode.Store(modes[DefaultMode])
+
+	actionChan := make(chan []Action, 10)
+	activityChan := make(chan struct{}, 1)
+
+	// 被监视的文件变化后 reload 子进程
+	if len(cfg.Watch) > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		goSafe(func() {
+			watchFiles(cfg.Watch, done, func() {
+				select {
+				case actionChan <- []Action{{Type: ActionTypeReload}}:
+				case <-done:
+				}
+			})
+		})
+	}
+
+	// Run 返回时停止读取按键的两个协程，之后的按键留给调用方 (Config.Tty 由调用方传入时)
+	reader, err := newTTYReader(tty)
+	if err != nil {
+		return 1, err
+	}
+	keysDone := make(chan struct{})
+	var keysWG sync.WaitGroup
+	keysWG.Add(2)
+	defer func() {
+		close(keysDone)
+		reader.stop()
+		keysWG.Wait()
+		reader.close()
+	}()
+
+	keyChan := make(chan []byte)
+	goSafe(func() {
+		defer keysWG.Done()
+		defer close(keyChan)
+		buf := make([]byte, bufferSize)
+		for {
+			n, err := reader.read(buf)
+			if n > 0 {
+				select {
+				case keyChan <- append([]byte(nil), buf[:n]...):
+				case <-keysDone:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+
+	goSafe(func() {
+		defer keysWG.Done()
+		// 主循环返回后不再接收 action
+		dispatch := func(actions []Action) {
+			select {
+			case actionChan <- actions:
+			case <-keysDone:
+			}
+		}
+		isDebug := os.Getenv("DEBUG") == "1"
+		var paste pasteState
+		// 粘贴结束标记之后同一次 read 到的按键，下一轮先处理
+		var pending []byte
+		for {
+			received := pending
+			pending = nil
+			if received == nil {
+				var ok bool
+				if received, ok = <-keyChan; !ok {
+					return
+				}
+			}
+			km := activeMode.Load()
+			// 转义序列可能被拆成多次 read 到达 (例如 ssh 较慢时)，收到的是已绑定序列的前缀时稍等一会儿拼接后续字节。
+			// gg 这样的连按由人手输入，等待时间更长
+		assemble:
+			for !paste.active && (km.prefixes[string(received)] || isPasteStartPrefix(received)) {
+				wait := 50 * time.Millisecond
+				if received[0] != '\x1b' {
+					wait = chordTimeout
+				}
+				select {
+				case more, ok := <-keyChan:
+					if !ok {
+						break assemble
+					}
+					// 例如 gg 只按了一个 g 就按了 q：g 按超时处理，q 下一轮重新查找绑定
+					if km.breaksChord(received, more) {
+						pending = more
+						break assemble
+					}
+					received = append(received, more...)
+				case <-time.After(wait):
+					break assemble
+				}
+			}
+			// 粘贴的内容原样转发，不查 keymap；结束后触发 paste 绑定
+			if paste.active || strings.HasPrefix(string(received), pasteStart) && !held.Load() && !helpOpen.Load() {
+				forward, ended, rest := paste.feed(received)
+				if !cfg.Once && !cfg.NoForward || editing.Load() {
+					if _, err := ptmx.Write(forward); err != nil {
+						logger.Printf("Error writing to pty: %v\n", err)
+					}
+				}
+				if actions, bound := km.keys[pasteEnd]; ended && bound && !editing.Load() {
+					dispatch(actions)
+				}
+				if len(rest) > 0 {
+					pending = rest
+				}
+				select {
+				case activityChan <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			// 鼠标事件带有坐标，去掉坐标后再查 keymap，未绑定时原样转发给子进程
+			lookup := string(received)
+			if cfg.Mouse {
+				lookup = normalizeMouse(lookup)
+			}
+			// passthrough 中的按键以及编辑器运行期间的按键不查 keymap，直接转发
+			_, forced := passthrough[lookup]
+			forced = forced || editing.Load()
+			actions, bound := km.keys[lookup]
+			if forced {
+				actions, bound = nil, false
+			}
+			// 精确匹配失败后再尝试 raw 正则
+			for i := 0; !bound && !forced && i < len(km.raws); i++ {
+				if km.raws[i].re.Match(received) {
+					actions, bound = km.raws[i].actions, true
+				}
+			}
+			if cfg.KeyLog != nil {
+				logKey(cfg.KeyLog, received, actions)
+			}
+			select {
+			case activityChan <- struct{}{}:
+			default:
+			}
+			if isDebug {
+				log.Printf("%q %v %v\n", received, received, actions)
+			} else if held.Load() {
+				dispatch([]Action{{
+					Type: ActionTypeExit,
+				}})
+			} else if helpOpen.CompareAndSwap(true, false) {
+				// 帮助界面打开时，任意键都只用来关闭它
+				select {
+				case helpDoneChan <- struct{}{}:
+				case <-keysDone:
+				}
+			} else if bound {
+				dispatch(actions)
+			} else if string(received) == "\x1a" && !forced {
+				select {
+				case sigTstpChan <- syscall.SIGTSTP:
+				default:
+				}
+			} else if !cfg.Once && !cfg.NoForward || forced {
+				// 转发其他按键
+				// reload 期间 pty 可能已经关闭，丢弃这次按键即可
+				if _, err := ptmx.Write(received); err != nil {
+					logger.Printf("Error writing to pty: %v\n", err)
+				}
+			}
+		}
+	})
+
+	var scrollback *ringBuffer
+	if cfg.Scrollback > 0 {
+		scrollback = newRingBuffer(cfg.Scrollback)
+	}
+
+	// 将命令输出复制到标准输出，帮助界面显示期间暂停
+	screen := &pausableWriter{w: stdout}
+	// pause action 暂停了输出，帮助界面关闭时不恢复
+	outputPaused := false
+	outputs := []io.Writer{screen}
+	if cfg.OutputLog != nil {
+		outputLog := cfg.OutputLog
+		if cfg.OutputPlain {
+			outputLog = newANSIStripper(outputLog)
+		}
+		outputs = append(outputs, outputLog)
+	}
+	if cfg.Record != nil {
+		// header 中的大小取启动时 pty 的大小
+		width, height := 80, 24
+		if size, err := pty.GetsizeFull(tty); err == nil {
+			width, height = fitSize(int(size.Cols), cfg.Cols), fitSize(int(size.Rows), cfg.Rows)
+		}
+		cast, err := newCastWriter(cfg.Record, width, height)
+		if err != nil {
+			return 1, err
+		}
+		outputs = append(outputs, cast)
+	}
+	// 只有绑定了 screenshot 时才需要维护屏幕内容
+	var vt *vtScreen
+	if hasAction(cfg.Keymap, ActionTypeScreenshot) {
+		width, height := 80, 24
+		if size, err := pty.GetsizeFull(tty); err == nil {
+			width, height = fitSize(int(size.Cols), cfg.Cols), fitSize(int(size.Rows), cfg.Rows)
+		}
+		vt = newVTScreen(width, height)
+		outputs = append(outputs, vt)
+	}
+	output := io.MultiWriter(outputs...)
+	// 所有子进程 (包括 reload 之后的) 累计输出的字节数，超过 MaxOutput 时通知主循环退出
+	var outputBytes atomic.Int64
+	outputLimitChan := make(chan struct{}, 1)
+	copyOutput := func(ptmx *os.File) {
+		goSafe(func() {
+			buf := make([]byte, bufferSize)
+			for {
+				n, err := readRetry(ptmx, buf)
+				if n > 0 && cfg.MaxOutput > 0 {
+					total := outputBytes.Add(int64(n))
+					if total > cfg.MaxOutput {
+						// 只写出限额内的部分
+						n = max(n-int(total-cfg.MaxOutput), 0)
+						select {
+						case outputLimitChan <- struct{}{}:
+						default:
+						}
+					}
+				}
+				if n > 0 {
+					output.Write(buf[:n])
+					if scrollback != nil {
+						scrollback.Write(buf[:n])
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		})
+	}
+	copyOutput(ptmx)
+
+	// 用 cmd 替换当前的子进程和 pty，调用前子进程需要已经退出
+	restart := func(cmd []string) error {
+		ptmx.Close()
+		var err error
+		child, ptmx, err = spawn(cmd)
+		if err != nil {
+			return err
+		}
+		childCmd = cmd
+		startedAt = time.Now()
+		waitChild()
+		copyOutput(ptmx)
+		if err := resizePty(tty, ptmx, cfg.Cols, cfg.Rows); err != nil {
+			logger.Printf("Error resizing pty: %v\n", err)
+		}
+		return nil
+	}
+
+	// edit 的编辑器和被包装的命令一样运行在 pty 中，退出后重新启动 editReturn 中的命令。
+	// 编辑器不需要 --input，也不从 stdin 读取
+	var editReturn []string
+	startEditor := func(path string) error {
+		editor := os.Getenv("VISUAL")
+		if editor == "" {
+			editor = os.Getenv("EDITOR")
+		}
+		if editor == "" {
+			editor = "vi"
+		}
+		editCfg := cfg
+		editCfg.Input, editCfg.InputFile = "", ""
+		ptmx.Close()
+		var err error
+		child, ptmx, err = startPty([]string{posixShell, "-c", editor + ` "$0"`, path}, editCfg)
+		if err != nil {
+			return err
+		}
+		editReturn = childCmd
+		editing.Store(true)
+		waitChild()
+		copyOutput(ptmx)
+		if err := resizePty(tty, ptmx, cfg.Cols, cfg.Rows); err != nil {
+			logger.Printf("Error resizing pty: %v\n", err)
+		}
+		return nil
+	}
+
+	killSignal := cfg.KillSignal
+	if killSignal == 0 {
+		killSignal = syscall.SIGTERM
+	}
+	killTimeout := cfg.KillTimeout
+	if killTimeout == 0 {
+		killTimeout = 2 * time.Second
+	}
+	// pty.Start 让子进程在新会话中运行，子进程同时也是进程组的组长。
+	// 信号发给整个进程组，管道等方式启动的孙进程也一起停止
+	signalGroup := func(sig syscall.Signal) error {
+		return syscall.Kill(-child.Process.Pid, sig)
+	}
+	stopChild := func() {
+		if childExitChan == nil {
+			return
+		}
+
+		if killTimeout < 0 {
+			if err := signalGroup(syscall.SIGKILL); err != nil {
+				logger.Printf("Error killing child process: %v\n", err)
+			}
+			<-childExitChan
+			childExitChan = nil
+			return
+		}
+
+		// 先发送 killSignal，超过 killTimeout 仍未退出再 SIGKILL
+		err := signalGroup(killSignal)
+		if err != nil {
+			logger.Printf("Error sending %v to child: %v\n", killSignal, err)
+		}
+
+		for {
+			select {
+			case <-time.After(killTimeout):
+				// 超时后强制杀死子进程
+				logger.Println("Child process did not exit gracefully, sending SIGKILL")
+				err := signalGroup(syscall.SIGKILL)
+				if err != nil {
+					logger.Printf("Error killing child process: %v\n", err)
+				}
+			case <-childExitChan:
+				childExitChan = nil
+				return
+			}
+		}
+	}
+
+	// 每个 toggle 绑定当前运行的是第几条命令，被包装的命令视为第 0 条
+	toggleState := make(map[string]int)
+
+	// 替换 action 参数中的占位符：__stdin_file__ 为 stdin 的临时文件，__key__ 为触发 action 的按键名
+	expand := func(s string, action Action) string {
+		return strings.NewReplacer("__stdin_file__", stdinPath, "__key__", action.Key).Replace(s)
+	}
+	// become/execute 的环境变量，让命令可以找到被包装的子进程和 stdin 的临时文件
+	actionEnv := func() []string {
+		return append(cfg.environ(),
+			"KEYWRAP_CHILD_PID="+strconv.Itoa(child.Process.Pid),
+			"KEYWRAP_STDIN_FILE="+stdinPath,
+		)
+	}
+	// 运行 execute 类命令，超过 ExecTimeout 时杀掉，避免卡住主循环
+	runExecute := func(cmd *exec.Cmd) error {
+		if cmd.Stdout != io.Discard {
+			io.WriteString(stdout, resetTerminal)
+		}
+		if cfg.ExecTimeout <= 0 {
+			return cmd.Run()
+		}
+		// 命令的后台进程可能还占着输出管道，被杀掉后不再等待它们
+		cmd.WaitDelay = time.Second
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		timer := time.AfterFunc(cfg.ExecTimeout, func() {
+			logger.Printf("Warning: command took longer than %v, killing it: %s\n", cfg.ExecTimeout, strings.Join(cmd.Args, " "))
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+		return cmd.Wait()
+	}
+
+	// execute-async 的命令交给后台协程按顺序运行，输出不会交错；结果通过 asyncDoneChan 交回主循环
+	asyncQueue := make(chan *exec.Cmd, 64)
+	asyncDoneChan := make(chan error)
+	asyncStop := make(chan struct{})
+	defer close(asyncStop)
+	goSafe(func() {
+		for {
+			select {
+			case cmd := <-asyncQueue:
+				err := runExecute(cmd)
+				select {
+				case asyncDoneChan <- err:
+				case <-asyncStop:
+					return
+				}
+			case <-asyncStop:
+				return
+			}
+		}
+	})
+
+	// 空闲超时，未设置时 idleChan 为 nil，永远不会触发
+	var idleChan <-chan time.Time
+	if cfg.Timeout > 0 {
+		idleTimer := time.NewTimer(cfg.Timeout)
+		defer idleTimer.Stop()
+		idleChan = idleTimer.C
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-activityChan:
+					idleTimer.Reset(cfg.Timeout)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	// 等待重试时不为 nil，到时间后重新启动 childCmd
+	var retryChan <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopChild()
+			return 1, ctx.Err()
+		case err := <-panicChan:
+			stopChild()
+			return 2, err
+		case <-helpDoneChan:
+			// 先清掉帮助界面，再写出暂存的输出，最后让子进程重绘
+			io.WriteString(stdout, "\x1b[2J\x1b[H")
+			drawHeader()
+			if !outputPaused {
+				screen.resume()
+			}
+			child.Process.Signal(syscall.SIGWINCH)
+		case <-outputLimitChan:
+			stopChild()
+			logger.Printf("Output limit of %d bytes reached, stopping the command\n", cfg.MaxOutput)
+			return 4, nil
+		case <-retryChan:
+			retryChan = nil
+			// 等待期间 reload 等 action 已经启动了新进程
+			if childExitChan != nil {
+				break
+			}
+			err := restart(childCmd)
+			// 和启动时一样，重试次数用完后依次尝试备选命令
+			for err != nil && retries == 0 && len(fallbacks) > 0 {
+				logger.Printf("Error starting %s: %v, trying fallback\n", childCmd[0], err)
+				childCmd, fallbacks = wrapStdin(fallbacks[0]), fallbacks[1:]
+				retries = cfg.Retry
+				err = restart(childCmd)
+			}
+			if err != nil {
+				if retries == 0 {
+					return startErrorCode(err), err
+				}
+				retries--
+				logger.Printf("Error starting %s: %v, retrying in %v\n", childCmd[0], err, cfg.RetryDelay)
+				retryChan = time.After(cfg.RetryDelay)
+			}
+		case err := <-asyncDoneChan:
+			if err != nil {
+				logger.Println(err)
+			}
+		case <-idleChan:
+			logger.Println("No input for too long, exiting")
+			stopChild()
+			return 0, nil
+		case err := <-childExitChan:
+			childExitChan = nil
+			// 编辑器退出，回到原来的命令
+			if editReturn != nil {
+				cmd := editReturn
+				editReturn = nil
+				editing.Store(false)
+				io.WriteString(stdout, resetTerminal+"\x1b[2J\x1b[H")
+				drawHeader()
+				if err := restart(cmd); err != nil {
+					return startErrorCode(err), err
+				}
+				break
+			}
+			if err != nil {
+				logger.Printf("Command finished with error: %v\n", err)
+			}
+			// 启动后很快就失败，多半是命令暂时不可用，先重试，再换下一个备选命令
+			if err != nil && retries > 0 && time.Since(startedAt) < fallbackWindow {
+				retries--
+				logger.Printf("Retrying in %v\n", cfg.RetryDelay)
+				retryChan = time.After(cfg.RetryDelay)
+				break
+			}
+			if err != nil && len(fallbacks) > 0 && time.Since(startedAt) < fallbackWindow {
+				next := wrapStdin(fallbacks[0])
+				fallbacks = fallbacks[1:]
+				retries = cfg.Retry
+				if err := restart(next); err != nil {
+					return startErrorCode(err), err
+				}
+				break
+			}
+			if !cfg.Hold {
+				return exitCode(err), nil
+			}
+			holdMessage := cfg.HoldMessage
+			if holdMessage == "" {
+				holdMessage = "Child process exited, press any key to exit..."
+			}
+			log.Println(holdMessage)
+			held.Store(true)
+		case sig := <-sigChan:
+			if childExitChan == nil {
+				return 128 + int(sig.(syscall.Signal)), nil
+			}
+			if err := child.Process.Signal(sig); err != nil {
+				logger.Printf("Error forwarding %v to child: %v\n", sig, err)
+			}
+		case <-sigTstpChan:
+			term.Restore(int(tty.Fd()), oldState)
+			if childExitChan != nil {
+				syscall.Kill(-child.Process.Pid, syscall.SIGSTOP) // 子进程在自己的会话里，需要单独暂停
+			}
+			syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+			// 收到 SIGCONT (fg) 后从这里继续执行
+			if _, err := term.MakeRaw(int(tty.Fd())); err != nil {
+				logger.Printf("Error entering raw mode: %v\n", err)
+			}
+			if err := resizePty(tty, ptmx, cfg.Cols, cfg.Rows); err != nil {
+				logger.Printf("Error resizing pty: %v\n", err)
+			}
+			if childExitChan != nil {
+				syscall.Kill(-child.Process.Pid, syscall.SIGCONT)
+			}
+		case <-sigWinchChan:
+			if err := resizePty(tty, ptmx, cfg.Cols, cfg.Rows); err != nil {
+				logger.Printf("Error resizing pty: %v\n", err)
+			}
+			if size, err := pty.GetsizeFull(ptmx); err == nil && vt != nil {
+				vt.resize(int(size.Cols), int(size.Rows))
+			}
+			drawHeader()
+		case actions := <-actionChan:
+			// 编辑器运行期间不执行 action (例如 --watch 触发的 reload)
+			if editReturn != nil {
+				break
+			}
+			// exit/become 不会返回，后面的 action 自然不再执行
+			for _, action := range actions {
+				switch action.Type {
+				case ActionTypeExit:
+					stopChild()
+					return 0, nil
+				case ActionTypeAbort:
+					stopChild()
+					return 130, nil // 与 Ctrl-C 中断时 shell 的退出码一致
+				case ActionTypeBecome, ActionTypeBecomeTty, ActionTypeBecomeStdin:
+					script, err := actionScript(action.Arg)
+					if err != nil {
+						logger.Println(err)
+						break
+					}
+					env := actionEnv()
+					stopChild()
+					arg := expand(script, action)
+					// become 的 stdin 保持 keywrap 自己的 stdin，-tty 改为从终端读取，-stdin 改为读取临时文件。
+					// 重定向由 posixShell 完成后再 exec Shell，Shell 不必支持 POSIX 语法
+					shell, args := cfg.Shell, []string{"-c", arg}
+					switch action.Type {
+					case ActionTypeBecomeTty:
+						shell, args = posixShell, []string{"-c", `exec "$0" -c "$1" </dev/tty`, cfg.Shell, arg}
+					case ActionTypeBecomeStdin:
+						shell, args = posixShell, []string{"-c", `exec "$0" -c "$1" <"$2"`, cfg.Shell, arg, stdinPath}
+					}
+					// exec 之后 defer 不会执行，新进程用不到的临时文件需要提前删除
+					if stdinFile != nil && !usesStdinFile(script) && action.Type != ActionTypeBecomeStdin {
+						os.Remove(stdinPath)
+					}
+					// 经过 posixShell 时先确认 Shell 存在，否则只能由 posixShell 报错。在恢复终端之前检查，失败时 keywrap 照常退出
+					if _, err := exec.LookPath(cfg.Shell); err != nil {
+						return 5, fmt.Errorf("%w %s: %w", ErrBecome, script, err)
+					}
+					if cfg.Title != "" {
+						io.WriteString(stdout, "\x1b[23;0t") // 恢复原标题，由新进程自己设置
+					}
+					if cfg.Mouse {
+						io.WriteString(stdout, "\x1b[?1006l\x1b[?1000l")
+					}
+					if cfg.Header != "" {
+						io.WriteString(stdout, "\x1b[r\x1b[?6l")
+					}
+					if cfg.AltScreen {
+						io.WriteString(stdout, "\x1b[?1049l")
+					}
+					runOnExit()
+					// 新进程从正常模式的终端开始，需要原始模式的程序会自己设置
+					io.WriteString(stdout, resetTerminal)
+					term.Restore(int(tty.Fd()), oldState)
+					terminalRestored = true
+					// 失败时终端已经恢复，只需要返回错误
+					if err := execSyscall(env, shell, args...); err != nil {
+						return 5, fmt.Errorf("%w %s: %w", ErrBecome, script, err)
+					}
+				case ActionTypeExecute:
+					script, err := actionScript(action.Arg)
+					if err != nil {
+						logger.Println(err)
+						break
+					}
+					arg := expand(script, action)
+					cmd := exec.Command(cfg.Shell, "-c", arg)
+					cmd.Env = actionEnv()
+					cmd.Stdout = stdout
+					cmd.Stderr = os.Stderr
+					if err := runExecute(cmd); err != nil {
+						logger.Println(err)
+					}
+				case ActionTypeExecuteAsync:
+					script, err := actionScript(action.Arg)
+					if err != nil {
+						logger.Println(err)
+						break
+					}
+					cmd := exec.Command(cfg.Shell, "-c", expand(script, action))
+					cmd.Env = actionEnv()
+					cmd.Stdout = stdout
+					cmd.Stderr = os.Stderr
+					select {
+					case asyncQueue <- cmd:
+					default:
+						logger.Printf("Too many pending execute-async commands, dropping: %s\n", action.Arg)
+					}
+				case ActionTypeExecuteRaw:
+					if len(action.Args) == 0 {
+						logger.Printf("execute-raw needs a command: %s\n", action.Arg)
+						break
+					}
+					args := make([]string, len(action.Args))
+					for i, arg := range action.Args {
+						args[i] = expand(arg, action)
+					}
+					cmd := cfg.command(args[0], args[1:]...)
+					cmd.Env = actionEnv()
+					cmd.Stdout = stdout
+					cmd.Stderr = os.Stderr
+					if err := runExecute(cmd); err != nil {
+						logger.Println(err)
+					}
+				case ActionTypeExecuteSilent:
+					script, err := actionScript(action.Arg)
+					if err != nil {
+						logger.Println(err)
+						break
+					}
+					arg := expand(script, action)
+					cmd := exec.Command(cfg.Shell, "-c", arg)
+					cmd.Env = actionEnv()
+					cmd.Stdout = io.Discard
+					cmd.Stderr = io.Discard
+					if err := runExecute(cmd); err != nil {
+						logger.Println(err)
+					}
+				case ActionTypeToggle:
+					if len(action.Args) != 2 {
+						logger.Printf("toggle needs two commands: %s\n", action.Arg)
+						break
+					}
+					next := 1 - toggleState[action.Arg]
+					toggleState[action.Arg] = next
+					stopChild()
+					io.WriteString(stdout, resetTerminal+"\x1b[2J\x1b[H")
+					drawHeader()
+					if err := restart(wrapStdin([]string{cfg.Shell, "-c", action.Args[next]})); err != nil {
+						return startErrorCode(err), err
+					}
+				case ActionTypeSend:
+					if _, err := io.WriteString(ptmx, action.Arg); err != nil {
+						logger.Printf("Error sending keys to child: %v\n", err)
+					}
+				case ActionTypeSendStdin:
+					if err := writeStdin([]byte(action.Arg)); err != nil {
+						logger.Printf("Error writing to stdin of child: %v\n", err)
+					}
+				case ActionTypeCopy:
+					// 参数以 @ 开头时复制文件内容，例如 copy(@__stdin_file__)
+					text, err := actionScript(expand(action.Arg, action))
+					if err != nil {
+						logger.Println(err)
+						break
+					}
+					if err := copyToClipboard(stdout, cfg.Shell, cfg.Clipboard, text); err != nil {
+						logger.Printf("Error copying to clipboard: %v\n", err)
+					}
+				case ActionTypeSignal:
+					sig, _ := ParseSignal(action.Arg)
+					if err := child.Process.Signal(sig); err != nil {
+						logger.Printf("Error sending %v to child: %v\n", sig, err)
+					}
+				case ActionTypeHelp:
+					if helpOpen.Load() {
+						break
+					}
+					screen.pause()
+					io.WriteString(stdout, "\x1b[0m\x1b[2J\x1b[H"+keymapHelp(cfg.Keymap)+"\r\nPress any key to continue")
+					helpOpen.Store(true)
+				case ActionTypeEdit:
+					stopChild()
+					io.WriteString(stdout, resetTerminal+"\x1b[2J\x1b[H")
+					drawHeader()
+					if err := startEditor(expand(action.Arg, action)); err != nil {
+						logger.Printf("Error starting editor: %v\n", err)
+						if err := restart(childCmd); err != nil {
+							return startErrorCode(err), err
+						}
+					}
+				case ActionTypePause:
+					// 再按一次恢复
+					if outputPaused {
+						outputPaused = false
+						screen.resume()
+						break
+					}
+					outputPaused = true
+					screen.pause()
+				case ActionTypeResume:
+					if outputPaused {
+						outputPaused = false
+						screen.resume()
+					}
+				case ActionTypeMode:
+					activeMode.Store(modes[action.Arg])
+				case ActionTypeScreenshot:
+					if err := os.WriteFile(expand(action.Arg, action), []byte(vt.String()), 0o644); err != nil {
+						logger.Printf("Error writing screenshot: %v\n", err)
+					}
+				case ActionTypeRefresh:
+					// 清屏后通知子进程窗口大小变化，大多数全屏程序会因此重绘
+					io.WriteString(stdout, "\x1b[2J\x1b[H")
+					drawHeader()
+					child.Process.Signal(syscall.SIGWINCH)
+				case ActionTypePrint:
+					printed = append(printed, action.Arg)
+				case ActionTypeReload:
+					stopChild()
+					io.WriteString(stdout, resetTerminal+"\x1b[2J\x1b[H") // 清屏，让新进程从干净的屏幕开始绘制
+					drawHeader()
+					if scrollback != nil {
+						stdout.Write(scrollback.Bytes())
+					}
+					if err := restart(childCmd); err != nil {
+						return startErrorCode(err), err
+					}
+				}
+			}
+			if cfg.Once {
+				stopChild()
+				return 0, nil
+			}
+		}
+	}
+}
+
+// 子进程在这段时间内以非 0 退出时才会重试或尝试备选命令
+const fallbackWindow = time.Second
+
+// 与 bash 一致：正常退出返回子进程的退出码，被信号终止返回 128+signum
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// 按 cols/rows 设置 pty 大小，为 0 的一边跟随终端，负数表示在终端大小的基础上减去
+func resizePty(tty, ptmx *os.File, cols, rows int) error {
+	if cols == 0 && rows == 0 {
+		return pty.InheritSize(tty, ptmx)
+	}
+	size, err := pty.GetsizeFull(tty)
+	if err != nil {
+		return err
+	}
+	size.Cols = uint16(fitSize(int(size.Cols), cols))
+	size.Rows = uint16(fitSize(int(size.Rows), rows))
+	return pty.Setsize(ptmx, size)
+}
+
+func fitSize(inherited, want int) int {
+	switch {
+	case want > 0:
+		return want
+	case want < 0:
+		return max(inherited+want, 1)
+	}
+	return inherited
+}
+
+// 启动失败时的退出码，与 shell 一致：找不到命令 127，无法执行 126
+func startErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrCommandNotFound):
+		return 127
+	case errors.Is(err, fs.ErrPermission):
+		return 126
+	}
+	return 1
+}
+
+// keywrap 自己拼出的命令 (wrapStdin、编辑器、become 的重定向) 使用 POSIX 语法，
+// 固定由 /bin/sh 执行；用户写的命令仍使用 Config.Shell，可以是 fish 等非 POSIX shell
+const posixShell = "/bin/sh"
+
+// 成功时当前进程被替换，只有失败才会返回
+func execSyscall(env []string, cmd string, args ...string) error {
+	binary, err := exec.LookPath(cmd)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(binary, append([]string{binary}, args...), env)
+}
+
+type keyEvent struct {
+	Bytes   string `json:"bytes"`
+	Hex     string `json:"hex"`
+	Matched string `json:"matched"`
+	Action  string `json:"action"`
+	Desc    string `json:"description,omitempty"`
+	Ts      string `json:"ts"`
+}
+
+func logKey(w io.Writer, received []byte, actions []Action) {
+	event := keyEvent{
+		Bytes: string(received),
+		Hex:   hex.EncodeToString(received),
+		Ts:    time.Now().Format(time.RFC3339Nano),
+	}
+	var names []string
+	for _, action := range actions {
+		event.Matched = action.Key
+		event.Desc = action.Desc
+		names = append(names, action.String())
+	}
+	event.Action = strings.Join(names, "+")
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		log.Printf("Error writing key log: %v\n", err)
+	}
+}
@@ -0,0 +1,30 @@
+package keywrap
+
+import "testing"
+
+func TestNeedsStdinFile(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"no bindings", Config{}, false},
+		{"exit", Config{Keymap: map[string]string{"q": "exit"}}, false},
+		{"become", Config{Keymap: map[string]string{"q": "become(less)"}}, false},
+		{"reload", Config{Keymap: map[string]string{"r": "reload"}}, true},
+		{"toggle", Config{Keymap: map[string]string{"t": "toggle(a,b)"}}, true},
+		{"edit", Config{Keymap: map[string]string{"e": "edit(/tmp/notes)"}}, true},
+		{"become-stdin", Config{Keymap: map[string]string{"q": "become-stdin(grep foo)"}}, true},
+		{"chained become-stdin", Config{Keymap: map[string]string{"q": "print(x)+become-stdin(grep foo)"}}, true},
+		{"stdin file", Config{Keymap: map[string]string{"q": "execute(wc -l __stdin_file__)"}}, true},
+		{"env", Config{Keymap: map[string]string{"q": "execute(wc -l $KEYWRAP_STDIN_FILE)"}}, true},
+		{"fallback", Config{Fallbacks: [][]string{{"cat"}}}, true},
+		{"watch", Config{Watch: []string{"a"}}, true},
+		{"retry", Config{Retry: 1}, true},
+	}
+	for _, tt := range tests {
+		if got := needsStdinFile(tt.cfg); got != tt.want {
+			t.Errorf("%s: needsStdinFile = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
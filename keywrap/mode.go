@@ -0,0 +1,114 @@
+package keywrap
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultMode 是没有指定模式的绑定所在的模式，启动时处于该模式，mode(default) 切换回来
+const DefaultMode = "default"
+
+var modeName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// 一个模式中解析好的绑定
+type modeKeymap struct {
+	keys     map[string][]Action
+	prefixes map[string]bool
+	raws     []rawBinding
+}
+
+// 已收到连按 (不以 ESC 开头) 的前缀 held，又收到 more。拼起来既没有绑定也不是绑定的前缀时，
+// 说明连按被其他按键打断，held 和 more 应分别查找绑定
+func (km *modeKeymap) breaksChord(held, more []byte) bool {
+	if len(held) == 0 || held[0] == '\x1b' {
+		return false
+	}
+	seq := string(held) + string(more)
+	if _, bound := km.keys[seq]; bound || km.prefixes[seq] {
+		return false
+	}
+	for _, raw := range km.raws {
+		if raw.re.MatchString(seq) {
+			return false
+		}
+	}
+	return true
+}
+
+// 按键名 <mode>:<key> 表示该绑定只在 mode 模式中生效
+func splitModeKey(k string) (mode, key string) {
+	if isRawKey(k) {
+		return DefaultMode, k
+	}
+	if m, rest, ok := strings.Cut(k, ":"); ok && rest != "" && modeName.MatchString(m) {
+		return m, rest
+	}
+	return DefaultMode, k
+}
+
+// 按模式拆分 keymap，结果中总有 DefaultMode
+func splitModes(keymap map[string]string) map[string]map[string]string {
+	modes := map[string]map[string]string{DefaultMode: {}}
+	for k, v := range keymap {
+		mode, key := splitModeKey(k)
+		if modes[mode] == nil {
+			modes[mode] = make(map[string]string)
+		}
+		modes[mode][key] = v
+	}
+	return modes
+}
+
+// 模式名按字典序排列，DefaultMode 在最前面
+func sortedModes(modes map[string]map[string]string) []string {
+	var names []string
+	for name := range modes {
+		if name != DefaultMode {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultMode}, names...)
+}
+
+// 解析每个模式的绑定，mode(name) 只能切换到有绑定的模式
+func formatModes(keymap map[string]string) (map[string]*modeKeymap, error) {
+	split := splitModes(keymap)
+	modes := make(map[string]*modeKeymap)
+	for _, name := range sortedModes(split) {
+		keys, err := formatKeymap(split[name])
+		if err != nil {
+			if name != DefaultMode {
+				err = fmt.Errorf("mode %s: %w", name, err)
+			}
+			return nil, err
+		}
+		modes[name] = &modeKeymap{keys, sequencePrefixes(keys), rawKeymap(split[name])}
+	}
+	for _, v := range keymap {
+		for _, action := range parseActions(v) {
+			if action.Type == ActionTypeMode && modes[action.Arg] == nil {
+				return nil, fmt.Errorf("unknown mode in %q: %s", v, action.Arg)
+			}
+		}
+	}
+	return modes, nil
+}
+
+// IsModeBind 判断 --bind 的参数是否为 <mode>:<key>:<action> 的形式，即第一个冒号之后不是 action。
+// 例如 normal:ctrl-e:become(nvim) 是，而 q:exit 和 ctrl-e:become(nvim):说明 不是
+func IsModeBind(bind string) bool {
+	mode, rest, ok := strings.Cut(bind, ":")
+	if !ok || mode == "raw" && strings.HasPrefix(rest, "/") || !modeName.MatchString(mode) || !strings.Contains(rest, ":") {
+		return false
+	}
+	// 缺少右括号的 action，例如 become(a:b，不是按键；单独的 ( 可以作为按键
+	if key, _, _ := strings.Cut(rest, ":"); len(key) > 1 && strings.Contains(key, "(") {
+		return false
+	}
+	v, _ := splitDescription(rest)
+	action, err := parseAction(splitTopLevel(v, '+')[0])
+	return err != nil || action.Type == ""
+}
@@ -0,0 +1,43 @@
+package keywrap
+
+import "bytes"
+
+// 括号粘贴 (bracketed paste) 的开始和结束标记。子进程输出 \x1b[?2004h 开启后，
+// 终端用它们包住粘贴的内容，这样粘贴的文字不会被当成按键
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// 收到的是被拆开的开始标记，需要等后续字节再判断
+func isPasteStartPrefix(b []byte) bool {
+	return len(b) > 2 && len(b) < len(pasteStart) && bytes.HasPrefix([]byte(pasteStart), b)
+}
+
+// 跟踪一次粘贴，粘贴可能分成多次 read 到达
+type pasteState struct {
+	active bool
+	carry  []byte // 末尾可能是被拆开的结束标记，留到下一次
+}
+
+// 处理粘贴期间读到的字节 (或以开始标记开头的字节)。返回原样转发给子进程的部分 (包括标记)、
+// 粘贴是否在这次结束，以及结束标记之后的按键
+func (p *pasteState) feed(b []byte) (forward []byte, ended bool, rest []byte) {
+	p.active = true
+	if len(p.carry) > 0 {
+		b = append(append([]byte(nil), p.carry...), b...)
+		p.carry = nil
+	}
+	if i := bytes.Index(b, []byte(pasteEnd)); i >= 0 {
+		p.active = false
+		end := i + len(pasteEnd)
+		return b[:end], true, b[end:]
+	}
+	for n := min(len(pasteEnd)-1, len(b)); n > 0; n-- {
+		if bytes.HasSuffix(b, []byte(pasteEnd[:n])) {
+			p.carry = b[len(b)-n:]
+			return b[:len(b)-n], false, nil
+		}
+	}
+	return b, false, nil
+}
@@ -0,0 +1,38 @@
+package keywrap
+
+import "testing"
+
+func TestPasteState(t *testing.T) {
+	tests := []struct {
+		name      string
+		reads     []string
+		forwarded string
+		rest      string
+	}{
+		{"whole", []string{"\x1b[200~abc\x1b[201~"}, "\x1b[200~abc\x1b[201~", ""},
+		{"keys after", []string{"\x1b[200~q\x1b[201~q"}, "\x1b[200~q\x1b[201~", "q"},
+		{"split", []string{"\x1b[200~a", "b", "c\x1b[201~"}, "\x1b[200~abc\x1b[201~", ""},
+		{"split end marker", []string{"\x1b[200~ab\x1b[2", "01~x"}, "\x1b[200~ab\x1b[201~", "x"},
+		{"escape in paste", []string{"\x1b[200~\x1b[A\x1b[2", "x\x1b[201~"}, "\x1b[200~\x1b[A\x1b[2x\x1b[201~", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p pasteState
+			var forwarded, rest string
+			for i, read := range tt.reads {
+				forward, ended, r := p.feed([]byte(read))
+				forwarded += string(forward)
+				if last := i == len(tt.reads)-1; ended != last {
+					t.Fatalf("read %d: ended = %v, want %v", i, ended, last)
+				}
+				rest = string(r)
+			}
+			if forwarded != tt.forwarded || rest != tt.rest {
+				t.Errorf("forwarded %q, rest %q; want %q, %q", forwarded, rest, tt.forwarded, tt.rest)
+			}
+			if p.active {
+				t.Errorf("paste still active")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package keywrap
+
+import (
+	"io"
+	"sync"
+)
+
+// 暂停输出期间 (pause action、帮助界面) 最多暂存这么多子进程输出，超出部分丢弃最旧的
+const pauseHoldSize = 64 * 1024
+
+// pausableWriter 暂停期间把写入的内容存进 ringBuffer，恢复时一次写出
+type pausableWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	paused *ringBuffer
+}
+
+func (p *pausableWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused != nil {
+		return p.paused.Write(b)
+	}
+	return p.w.Write(b)
+}
+
+func (p *pausableWriter) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == nil {
+		p.paused = newRingBuffer(pauseHoldSize)
+	}
+}
+
+func (p *pausableWriter) resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == nil {
+		return nil
+	}
+	held := p.paused.Bytes()
+	p.paused = nil
+	_, err := p.w.Write(held)
+	return err
+}
@@ -0,0 +1,342 @@
+package keywrap
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// vtScreen 是一个简化的终端屏幕模型，供 screenshot 保存子进程当前显示的文字。
+// 只处理光标移动、清除、滚动和备用屏幕等常见控制序列，忽略颜色等属性，每个字符占一格
+type vtScreen struct {
+	mu             sync.Mutex
+	cols, rows     int
+	main, alt      [][]rune
+	grid           [][]rune // 当前显示的是 main 或 alt
+	onAlt          bool
+	x, y           int
+	savedX, savedY int
+	top, bottom    int // 滚动区域，包含两端
+	state          int // 与 ansiStripper 相同的解析状态
+	params         []byte
+	pending        []byte // 被拆在两次 Write 之间的 UTF-8 字符
+}
+
+func newVTScreen(cols, rows int) *vtScreen {
+	s := &vtScreen{}
+	s.resize(cols, rows)
+	return s
+}
+
+func newGrid(cols, rows int) [][]rune {
+	grid := make([][]rune, rows)
+	for i := range grid {
+		grid[i] = blankLine(cols)
+	}
+	return grid
+}
+
+func blankLine(cols int) []rune {
+	line := make([]rune, cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// 调整大小，保留左上角重叠部分的内容
+func (s *vtScreen) resize(cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cols, rows = max(cols, 1), max(rows, 1)
+	copyGrid := func(old [][]rune) [][]rune {
+		grid := newGrid(cols, rows)
+		for y := 0; y < min(rows, len(old)); y++ {
+			copy(grid[y], old[y])
+		}
+		return grid
+	}
+	s.main, s.alt = copyGrid(s.main), copyGrid(s.alt)
+	s.grid = s.main
+	if s.onAlt {
+		s.grid = s.alt
+	}
+	s.cols, s.rows = cols, rows
+	s.top, s.bottom = 0, rows-1
+	s.clampCursor()
+}
+
+func (s *vtScreen) clampCursor() {
+	s.x = min(max(s.x, 0), s.cols-1)
+	s.y = min(max(s.y, 0), s.rows-1)
+}
+
+// String 返回屏幕上的文字，去掉行尾和末尾的空白
+func (s *vtScreen) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, len(s.grid))
+	for i, line := range s.grid {
+		lines[i] = strings.TrimRight(string(line), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n"
+}
+
+func (s *vtScreen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range p {
+		switch s.state {
+		case ansiText:
+			s.text(c)
+		case ansiEsc:
+			s.escape(c)
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				s.state = ansiText
+				s.csi(c)
+			} else {
+				s.params = append(s.params, c)
+			}
+		case ansiString:
+			switch c {
+			case '\x07':
+				s.state = ansiText
+			case '\x1b':
+				s.state = ansiStrEsc
+			}
+		case ansiStrEsc:
+			if c == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiString
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (s *vtScreen) text(c byte) {
+	if len(s.pending) > 0 || c >= 0x80 {
+		s.pending = append(s.pending, c)
+		if !utf8.FullRune(s.pending) {
+			return
+		}
+		r, _ := utf8.DecodeRune(s.pending)
+		s.pending = s.pending[:0]
+		s.put(r)
+		return
+	}
+	switch c {
+	case '\x1b':
+		s.state = ansiEsc
+	case '\r':
+		s.x = 0
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		s.x = max(s.x-1, 0)
+	case '\t':
+		s.x = min((s.x/8+1)*8, s.cols-1)
+	default:
+		if c >= ' ' && c != 0x7f {
+			s.put(rune(c))
+		}
+	}
+}
+
+func (s *vtScreen) put(r rune) {
+	// 写到行尾之后再写字符时换到下一行
+	if s.x >= s.cols {
+		s.x = 0
+		s.lineFeed()
+	}
+	s.grid[s.y][s.x] = r
+	s.x++
+}
+
+func (s *vtScreen) lineFeed() {
+	if s.y == s.bottom {
+		s.scrollUp(1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+// 滚动区域内的内容上移 n 行，底部补空行
+func (s *vtScreen) scrollUp(n int) {
+	for ; n > 0; n-- {
+		copy(s.grid[s.top:s.bottom], s.grid[s.top+1:s.bottom+1])
+		s.grid[s.bottom] = blankLine(s.cols)
+	}
+}
+
+// 滚动区域内的内容下移 n 行，顶部补空行
+func (s *vtScreen) scrollDown(n int) {
+	for ; n > 0; n-- {
+		copy(s.grid[s.top+1:s.bottom+1], s.grid[s.top:s.bottom])
+		s.grid[s.top] = blankLine(s.cols)
+	}
+}
+
+func (s *vtScreen) escape(c byte) {
+	s.state = ansiText
+	switch {
+	case c == '[':
+		s.state = ansiCSI
+		s.params = s.params[:0]
+	case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+		s.state = ansiString
+	case c >= 0x20 && c <= 0x2f:
+		s.state = ansiEsc // 中间字节，例如 ESC ( B，继续等待最终字节
+	case c == '7':
+		s.savedX, s.savedY = s.x, s.y
+	case c == '8':
+		s.x, s.y = s.savedX, s.savedY
+		s.clampCursor()
+	case c == 'D':
+		s.lineFeed()
+	case c == 'E':
+		s.x = 0
+		s.lineFeed()
+	case c == 'M':
+		if s.y == s.top {
+			s.scrollDown(1)
+		} else {
+			s.y = max(s.y-1, 0)
+		}
+	case c == 'c':
+		s.grid, s.onAlt = s.main, false
+		s.clear(0, 0, s.cols, s.rows)
+		s.x, s.y = 0, 0
+		s.top, s.bottom = 0, s.rows-1
+	}
+}
+
+// 清除 [x0, x1) × [y0, y1) 的矩形区域
+func (s *vtScreen) clear(x0, y0, x1, y1 int) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			s.grid[y][x] = ' '
+		}
+	}
+}
+
+func (s *vtScreen) csi(final byte) {
+	private := len(s.params) > 0 && s.params[0] == '?'
+	var nums []int
+	for _, p := range strings.Split(strings.TrimLeft(string(s.params), "?<=>"), ";") {
+		n, _ := strconv.Atoi(p)
+		nums = append(nums, n)
+	}
+	// 第 i 个参数，缺省或为 0 时返回 def
+	arg := func(i, def int) int {
+		if i < len(nums) && nums[i] > 0 {
+			return nums[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'A':
+		s.y -= arg(0, 1)
+	case 'B', 'e':
+		s.y += arg(0, 1)
+	case 'C', 'a':
+		s.x += arg(0, 1)
+	case 'D':
+		s.x -= arg(0, 1)
+	case 'E':
+		s.x, s.y = 0, s.y+arg(0, 1)
+	case 'F':
+		s.x, s.y = 0, s.y-arg(0, 1)
+	case 'G', '`':
+		s.x = arg(0, 1) - 1
+	case 'd':
+		s.y = arg(0, 1) - 1
+	case 'H', 'f':
+		s.y, s.x = arg(0, 1)-1, arg(1, 1)-1
+	case 'J':
+		s.clampCursor()
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.x, s.y, s.cols, s.y+1)
+			s.clear(0, s.y+1, s.cols, s.rows)
+		case 1:
+			s.clear(0, 0, s.cols, s.y)
+			s.clear(0, s.y, s.x+1, s.y+1)
+		default:
+			s.clear(0, 0, s.cols, s.rows)
+		}
+	case 'K':
+		s.clampCursor()
+		switch arg(0, 0) {
+		case 0:
+			s.clear(s.x, s.y, s.cols, s.y+1)
+		case 1:
+			s.clear(0, s.y, s.x+1, s.y+1)
+		default:
+			s.clear(0, s.y, s.cols, s.y+1)
+		}
+	case 'X':
+		s.clampCursor()
+		s.clear(s.x, s.y, min(s.x+arg(0, 1), s.cols), s.y+1)
+	case 'P', '@':
+		s.clampCursor()
+		line := s.grid[s.y]
+		n := min(arg(0, 1), s.cols-s.x)
+		if final == 'P' {
+			copy(line[s.x:], line[s.x+n:])
+			s.clear(s.cols-n, s.y, s.cols, s.y+1)
+		} else {
+			copy(line[s.x+n:], line[s.x:])
+			s.clear(s.x, s.y, s.x+n, s.y+1)
+		}
+	case 'L', 'M':
+		// 在光标所在行插入/删除行，只影响滚动区域
+		if s.y < s.top || s.y > s.bottom {
+			break
+		}
+		top := s.top
+		s.top = s.y
+		if final == 'L' {
+			s.scrollDown(arg(0, 1))
+		} else {
+			s.scrollUp(arg(0, 1))
+		}
+		s.top = top
+	case 'S':
+		s.scrollUp(arg(0, 1))
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+		}
+		s.x, s.y = 0, 0
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.x, s.y = s.savedX, s.savedY
+	case 'h', 'l':
+		if !private {
+			break
+		}
+		for _, n := range nums {
+			if n != 47 && n != 1047 && n != 1049 {
+				continue
+			}
+			if final == 'h' && !s.onAlt {
+				s.savedX, s.savedY = s.x, s.y
+				s.grid, s.onAlt = s.alt, true
+				s.clear(0, 0, s.cols, s.rows)
+			} else if final == 'l' && s.onAlt {
+				s.grid, s.onAlt = s.main, false
+				s.x, s.y = s.savedX, s.savedY
+			}
+		}
+	}
+	s.clampCursor()
+}
@@ -0,0 +1,33 @@
+package keywrap
+
+import "testing"
+
+func TestVTScreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"text", "hello\r\nworld", "hello\nworld\n"},
+		{"scroll", "1\r\n2\r\n3\r\n4\r\n5", "3\n4\n5\n"},
+		{"wrap", "abcdefgh", "abcde\nfgh\n"},
+		{"cursor", "abc\x1b[1;2Hx\x1b[3;4Hy", "axc\n\n   y\n"},
+		{"erase", "abcde\x1b[1;3H\x1b[K\r\nxyz\x1b[2J", "\n"},
+		{"utf8", "中文\x1b[31m!\x1b[0m", "中文!\n"},
+		{"alt screen", "main\x1b[?1049hother\x1b[?1049l", "main\n"},
+		{"osc", "\x1b]0;title\x07ok", "ok\n"},
+		{"delete char", "abcde\x1b[1;2H\x1b[2P", "ade\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newVTScreen(5, 3)
+			// 逐字节写入，检查被拆开的转义序列和 UTF-8 字符
+			for i := 0; i < len(tt.output); i++ {
+				s.Write([]byte{tt.output[i]})
+			}
+			if got := s.String(); got != tt.want {
+				t.Errorf("screen = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package keywrap
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ttyReader 读取按键，并且可以在 Run 返回时中断正在等待的读取。
+// Config.Tty 由调用方传入时不能关闭它，也不能改成非阻塞模式 (stdin/stdout 可能共用同一个文件描述)，
+// 所以用 select 同时等待 tty 和一个管道，stop 关闭管道的写端来唤醒。
+// 不用 poll 是因为 macOS 的 poll 不支持终端设备
+type ttyReader struct {
+	tty          *os.File
+	wakeR, wakeW *os.File
+}
+
+func newTTYReader(tty *os.File) (*ttyReader, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &ttyReader{tty: tty, wakeR: r, wakeW: w}, nil
+}
+
+// 等到 tty 有数据时才读取，stop 之后返回 io.EOF，不会再读走调用方的按键
+func (t *ttyReader) read(buf []byte) (int, error) {
+	fd, wake := int(t.tty.Fd()), int(t.wakeR.Fd())
+	for {
+		var fds unix.FdSet
+		fds.Set(fd)
+		fds.Set(wake)
+		if _, err := unix.Select(max(fd, wake)+1, &fds, nil, nil, nil); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, err
+		}
+		if fds.IsSet(wake) {
+			return 0, io.EOF
+		}
+		if fds.IsSet(fd) {
+			return readRetry(t.tty, buf)
+		}
+	}
+}
+
+// 唤醒正在等待的 read。只关闭自己的管道，tty 仍由调用方关闭
+func (t *ttyReader) stop() {
+	t.wakeW.Close()
+}
+
+// 在 read 返回之后调用
+func (t *ttyReader) close() {
+	t.wakeR.Close()
+}
@@ -0,0 +1,55 @@
+package keywrap
+
+import (
+	"os"
+	"time"
+)
+
+// 轮询间隔，同时也是去抖动的时间：文件变化后要等到下一次轮询时不再变化才通知
+const watchInterval = 300 * time.Millisecond
+
+type fileState struct {
+	mtime time.Time
+	size  int64
+}
+
+// 轮询 paths 的修改时间和大小，变化并稳定下来后调用 onChange，done 关闭时退出。
+// 不存在的文件视为空文件，之后被创建时同样会触发
+func watchFiles(paths []string, done <-chan struct{}, onChange func()) {
+	stat := func() []fileState {
+		states := make([]fileState, len(paths))
+		for i, p := range paths {
+			if info, err := os.Stat(p); err == nil {
+				states[i] = fileState{info.ModTime(), info.Size()}
+			}
+		}
+		return states
+	}
+	equal := func(a, b []fileState) bool {
+		for i := range a {
+			if !a[i].mtime.Equal(b[i].mtime) || a[i].size != b[i].size {
+				return false
+			}
+		}
+		return true
+	}
+
+	last := stat()
+	changed := false
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			current := stat()
+			if !equal(current, last) {
+				last, changed = current, true
+			} else if changed {
+				changed = false
+				onChange()
+			}
+		}
+	}
+}
@@ -1,303 +1,702 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"maps"
 	"os"
-	"os/exec"
-	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
-	"github.com/creack/pty"
-	"golang.org/x/term"
+	"github.com/urie96/keywrap/keywrap"
 )
 
+// 发布时通过 -ldflags "-X main.version=..." 注入
+var version = "dev"
+
 type ParsedFlag struct {
-	Cmd    []string
-	Keymap map[string]string
-	Hold   bool
-	Input  string
+	keywrap.Config
+	DryRun bool
+	Keys   bool // 只显示按键，不运行命令
 }
 
-func parseFlag() ParsedFlag {
+const usage = `Usage: keywrap [OPTIONS] -- <command> [args...]
+       keywrap [OPTIONS] --cmd "<command> [args...]" [OPTIONS]
+       keywrap [OPTIONS] --script <file> [OPTIONS] [-- args...]`
+
+func parseFlag() (ParsedFlag, error) {
 	parsed := ParsedFlag{
-		Keymap: make(map[string]string),
+		Config: keywrap.Config{
+			Keymap: make(map[string]string),
+		},
 	}
-	printHelp := func() {
-		log.Fatal("Usage: keywrap --bind \"ctrl-e:become(nvim a.json)\" -- bat a.json")
+	// --bindfile 中的绑定优先级低于命令行的 --bind，解析完成后再合并
+	fileKeymap := make(map[string]string)
+	fallback := false
+	// 使用 --cmd 或 --script 时，之后的参数仍然是 keywrap 的 flag
+	cmdFlag := false
+	script := ""
+
+	// 配置文件提供默认值，命令行 flag 在其之上覆盖
+	configKeymap := make(map[string]string)
+	if err := readConfigFile(configPath(), &parsed.Config, configKeymap); err != nil {
+		return parsed, err
 	}
+	envKeymap := make(map[string]string)
+	readEnvBinds(os.Getenv("KEYWRAP_BIND"), envKeymap)
 
 	args := os.Args[1:]
+	// 取出当前 flag 的参数值，并把 args 向后移动
+	value := func() (string, error) {
+		if len(args) < 2 {
+			return "", fmt.Errorf("flag needs an argument: %s", args[0])
+		}
+		v := args[1]
+		args = args[2:]
+		return v, nil
+	}
 	for len(args) > 0 {
 		switch args[0] {
 		case "--":
 			parsed.Cmd = args[1:]
 			args = nil
+			if fallback {
+				// 用后续的 -- 把命令分成多组，第一组之后的都是备选命令
+				var groups [][]string
+				start := 0
+				for i, arg := range parsed.Cmd {
+					if arg == "--" {
+						groups = append(groups, parsed.Cmd[start:i])
+						start = i + 1
+					}
+				}
+				groups = append(groups, parsed.Cmd[start:])
+				parsed.Cmd, parsed.Fallbacks = groups[0], groups[1:]
+				for _, g := range parsed.Fallbacks {
+					if len(g) == 0 {
+						return parsed, errors.New("empty fallback command")
+					}
+				}
+			}
+		case "--cmd":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if script != "" {
+				return parsed, errors.New("--cmd cannot be combined with --script")
+			}
+			if parsed.Cmd, err = keywrap.SplitArgs(v); err != nil {
+				return parsed, fmt.Errorf("invalid --cmd: %w", err)
+			}
+			cmdFlag = true
+		case "--script":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if cmdFlag {
+				return parsed, errors.New("--script cannot be combined with --cmd")
+			}
+			if _, err := os.Stat(v); err != nil {
+				return parsed, fmt.Errorf("invalid --script: %w", err)
+			}
+			script = v
+			cmdFlag = true
+		case "--fallback":
+			fallback = true
+			args = args[1:]
 		case "--bind":
-			keymap := strings.SplitN(args[1], ":", 2)
-			if len(keymap) != 2 {
-				printHelp()
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if err := parseBind(v, parsed.Keymap); err != nil {
+				return parsed, err
+			}
+		case "--bindfile":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if err := readBindFile(v, fileKeymap); err != nil {
+				return parsed, err
+			}
+		case "--timeout":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --timeout %q", v)
+			}
+			parsed.Timeout = timeout
+		case "--execute-timeout":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --execute-timeout %q", v)
+			}
+			parsed.ExecTimeout = timeout
+		case "--retry":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return parsed, fmt.Errorf("invalid --retry %q", v)
+			}
+			parsed.Retry = n
+		case "--retry-delay":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			delay, err := time.ParseDuration(v)
+			if err != nil || delay < 0 {
+				return parsed, fmt.Errorf("invalid --retry-delay %q", v)
+			}
+			parsed.RetryDelay = delay
+		case "--max-output-bytes":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			limit, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || limit < 0 {
+				return parsed, fmt.Errorf("invalid --max-output-bytes %q", v)
+			}
+			parsed.MaxOutput = limit
+		case "--scrollback":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			size, err := strconv.Atoi(v)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --scrollback %q", v)
+			}
+			parsed.Scrollback = size
+		case "--title":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Title = v
+		case "--alt-screen":
+			parsed.AltScreen = true
+			args = args[1:]
+		case "--mouse":
+			parsed.Mouse = true
+			args = args[1:]
+		case "--keys":
+			parsed.Keys = true
+			args = args[1:]
+		case "--dry-run":
+			parsed.DryRun = true
+			args = args[1:]
+		case "--no-stdin":
+			parsed.NoStdin = true
+			args = args[1:]
+		case "--once":
+			parsed.Once = true
+			args = args[1:]
+		case "--no-forward":
+			parsed.NoForward = true
+			args = args[1:]
+		case "--clipboard":
+			v, err := value()
+			if err != nil {
+				return parsed, err
 			}
-			parsed.Keymap[keymap[0]] = strings.TrimSpace(keymap[1])
-			args = args[2:]
+			parsed.Clipboard = v
+		case "--term":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Term = v
+		case "--colorterm":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.ColorTerm = v
+		case "--header":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Header = v
+		case "--strict":
+			parsed.Strict = true
+			args = args[1:]
+		case "--quiet", "-q":
+			parsed.Quiet = true
+			args = args[1:]
+		case "--log-json":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			f, err := os.Create(v)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.KeyLog = f
+		case "--record":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			f, err := os.Create(v)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Record = f
+		case "--separate-stderr":
+			parsed.SplitStderr = true
+			args = args[1:]
+		case "--output-log", "--output-log-plain":
+			parsed.OutputPlain = args[0] == "--output-log-plain"
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			f, err := os.Create(v)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OutputLog = f
+		case "--completion":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			script, err := completionScript(v)
+			if err != nil {
+				return parsed, err
+			}
+			fmt.Print(script)
+			os.Exit(0)
+		case "--version", "-V":
+			fmt.Println("keywrap", version)
+			os.Exit(0)
 		case "--hold", "-h":
 			parsed.Hold = true
 			args = args[1:]
+		case "--hold-message":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.HoldMessage = v
 		case "--input":
-			parsed.Input = args[1]
-			args = args[2:]
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Input = v
+		case "--input-file":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if _, err := os.Stat(v); err != nil {
+				return parsed, err
+			}
+			parsed.InputFile = v
+		case "--echo-input":
+			parsed.EchoInput = true
+			args = args[1:]
+		case "--input-delay":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			delay, err := time.ParseDuration(v)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --input-delay %q", v)
+			}
+			parsed.InputDelay = delay
+		case "--size":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if parsed.Cols, parsed.Rows, err = parseSize(v); err != nil {
+				return parsed, err
+			}
+		case "--on-exit":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OnExit = v
+		case "--watch":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Watch = append(parsed.Watch, v)
+		case "--passthrough":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			keys := strings.Split(v, ",")
+			if v == "," {
+				keys = []string{","}
+			}
+			parsed.Passthrough = append(parsed.Passthrough, keys...)
+		case "--kill-signal":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			sig, ok := keywrap.ParseSignal(v)
+			if !ok {
+				return parsed, fmt.Errorf("invalid --kill-signal %q", v)
+			}
+			parsed.KillSignal = sig
+		case "--kill-timeout":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --kill-timeout %q", v)
+			}
+			// Config 中 0 表示默认值，--kill-timeout 0 表示不等待直接 SIGKILL
+			if timeout == 0 {
+				timeout = -1
+			}
+			parsed.KillTimeout = timeout
+		case "--path":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Path = append(parsed.Path, v)
+		case "--shell":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Shell = v
+		case "--cwd":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Cwd = v
+		case "--env":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			if !strings.Contains(v, "=") {
+				return parsed, fmt.Errorf("invalid --env %q, expected KEY=VALUE", v)
+			}
+			parsed.Env = append(parsed.Env, v)
+		case "--buffer-size":
+			v, err := value()
+			if err != nil {
+				return parsed, err
+			}
+			size, err := strconv.Atoi(v)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --buffer-size %q", v)
+			}
+			parsed.BufferSize = size
 		default:
+			if cmdFlag {
+				return parsed, fmt.Errorf("unknown option %q", args[0])
+			}
 			parsed.Cmd = args
 			args = nil
 		}
 	}
-	if len(parsed.Cmd) == 0 {
-		printHelp()
+	// -- 之后的参数传给脚本
+	if script != "" {
+		parsed.Cmd = append(scriptCommand(script, parsed.Shell), parsed.Cmd...)
 	}
-	return parsed
-}
-
-func collectStdinToFile() *os.File {
-	if term.IsTerminal(int(os.Stdin.Fd())) {
-		return nil
+	if len(parsed.Cmd) == 0 && !parsed.Keys {
+		return parsed, errors.New("missing command to run")
 	}
-	stdinFile, err := os.CreateTemp("", "keywrap-stdin")
-	if err != nil {
-		panic(err)
+	for _, keymap := range []map[string]string{envKeymap, fileKeymap, configKeymap} {
+		for k, v := range keymap {
+			if _, ok := parsed.Keymap[k]; !ok {
+				parsed.Keymap[k] = v
+			}
+		}
 	}
-	io.Copy(stdinFile, os.Stdin)
-
-	return stdinFile
+	return parsed, nil
 }
 
-func startPty(cmd []string, preInput string) (*exec.Cmd, *os.File) {
-	child := exec.Command(cmd[0], cmd[1:]...)
-	child.Env = os.Environ()
-
-	ptmx, err := pty.Start(child)
-	if err != nil {
-		panic(err)
+// 有 #! 且可执行的脚本直接运行，其他的交给 shell 执行，与 Run 选择 shell 的顺序一致。
+// 转成绝对路径，--cwd 不影响脚本的位置
+func scriptCommand(path, shell string) []string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
 	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&0o111 != 0 {
+		if f, err := os.Open(path); err == nil {
+			head := make([]byte, 2)
+			n, _ := io.ReadFull(f, head)
+			f.Close()
+			if string(head[:n]) == "#!" {
+				return []string{path}
+			}
+		}
+	}
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return []string{shell, path}
+}
 
-	if preInput != "" {
-		_, err = ptmx.Write([]byte(preInput))
+// $XDG_CONFIG_HOME/keywrap/config，未设置 XDG_CONFIG_HOME 时使用 ~/.config
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
 		if err != nil {
-			panic(err)
+			return ""
 		}
+		dir = filepath.Join(home, ".config")
 	}
-
-	return child, ptmx
+	return filepath.Join(dir, "keywrap", "config")
 }
 
-func main() {
-	log.SetFlags(0)
-
-	flag := parseFlag()
-	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
-	if err != nil {
-		panic(err)
+// 每行一个 key=value，忽略空行和 # 开头的注释，文件不存在时不做任何事。
+// 支持 shell、buffer-size 和可重复的 bind
+func readConfigFile(path string, cfg *keywrap.Config, keymap map[string]string) error {
+	if path == "" {
+		return nil
 	}
-
-	childCmd := flag.Cmd
-
-	stdinFile := collectStdinToFile()
-	if stdinFile != nil {
-		defer stdinFile.Close()
-		childCmd = append([]string{"bash", "-c", `"$@" <"$0"; rm "$0"`, stdinFile.Name()}, childCmd...)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
 	}
-
-	child, ptmx := startPty(childCmd, flag.Input)
-	defer ptmx.Close()
-
-	// 设置终端为原始模式，以便直接读取按键
-	oldState, err := term.MakeRaw(int(tty.Fd()))
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer term.Restore(int(tty.Fd()), oldState)
-
-	// 处理终端大小变化
-	sigWinchChan := make(chan os.Signal, 1)
-	signal.Notify(sigWinchChan, syscall.SIGWINCH)
-	sigWinchChan <- syscall.SIGWINCH // 初始调整大小
-
-	childExitChan := make(chan error, 1)
-	go func() {
-		defer close(childExitChan)
-		childExitChan <- child.Wait()
-	}()
-
-	actionChan := make(chan Action, 10)
-
-	go func() {
-		buf := make([]byte, 1024)
-		keymap := formatKeymap(flag.Keymap)
-		isDebug := os.Getenv("DEBUG") == "1"
-		for {
-			n, err := tty.Read(buf)
-			if err != nil {
-				return
-			}
-			received := buf[:n]
-			if isDebug {
-				log.Printf("%q %v %s\n", received, received, keymap[string(received)])
-			} else if action, ok := keymap[string(received)]; ok {
-				actionChan <- action
-			} else if childExitChan == nil {
-				actionChan <- Action{
-					Type: ActionTypeExit,
-				}
-			} else {
-				// 转发其他按键
-				_, err = ptmx.Write(received)
-				if err != nil {
-					return
-				}
-			}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-	}()
-
-	// 将命令输出复制到标准输出
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := ptmx.Read(buf)
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected \"<name>=<value>\"", path, i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "shell":
+			cfg.Shell = value
+		case "buffer-size":
+			size, err := strconv.Atoi(value)
 			if err != nil {
-				return
+				return fmt.Errorf("%s:%d: invalid buffer-size %q", path, i+1, value)
 			}
-			os.Stdout.Write(buf[:n])
+			cfg.BufferSize = size
+		case "bind":
+			if err := parseBind(value, keymap); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+		default:
+			return fmt.Errorf("%s:%d: unknown option %q", path, i+1, key)
 		}
-	}()
+	}
+	return nil
+}
 
-	stopChild := func() {
-		if childExitChan == nil {
-			return
+// KEYWRAP_BIND 中是分号分隔的 key:action，括号内的分号属于 action 参数。
+// 格式不对的项只警告并跳过，不影响启动
+func readEnvBinds(v string, keymap map[string]string) {
+	depth, start := 0, 0
+	var binds []string
+	for i, c := range v {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ';':
+			if depth == 0 {
+				binds = append(binds, v[start:i])
+				start = i + 1
+			}
+		}
+	}
+	binds = append(binds, v[start:])
+	for _, bind := range binds {
+		bind = strings.TrimSpace(bind)
+		if bind == "" {
+			continue
+		}
+		// 先解析到单独的 map 中，按键名或 action 写错的条目整条跳过
+		entry := make(map[string]string)
+		err := parseBind(bind, entry)
+		if err == nil {
+			err = keywrap.ValidateBinding(entry)
 		}
-
-		// 发送SIGTERM信号
-		err := child.Process.Signal(syscall.SIGTERM)
 		if err != nil {
-			log.Printf("Error sending SIGTERM to child: %v\n", err)
+			log.Printf("keywrap: ignoring KEYWRAP_BIND entry %q: %v\n", bind, err)
+			continue
 		}
+		maps.Copy(keymap, entry)
+	}
+}
 
-		for {
-			select {
-			case <-time.After(2 * time.Second):
-				// 超时后强制杀死子进程
-				log.Println("Child process did not exit gracefully, sending SIGKILL")
-				err := child.Process.Kill()
-				if err != nil {
-					log.Printf("Error killing child process: %v\n", err)
-				}
-			case <-childExitChan:
-				childExitChan = nil
-				return
-			}
+func parseBind(bind string, keymap map[string]string) error {
+	// raw:/<regexp>/ 中可能有冒号和逗号，整体作为一个按键
+	if strings.HasPrefix(bind, "raw:/") {
+		end := strings.Index(bind[5:], "/:")
+		if end < 0 {
+			return fmt.Errorf("invalid bind %q, expected \"raw:/<regexp>/:<action>\"", bind)
+		}
+		key := bind[:5+end+1]
+		if _, err := regexp.Compile(key[5 : len(key)-1]); err != nil {
+			return fmt.Errorf("invalid bind %q: %w", bind, err)
 		}
+		keymap[key] = strings.TrimSpace(bind[5+end+2:])
+		return nil
 	}
-
-	for {
-		select {
-		case err := <-childExitChan:
-			childExitChan = nil
-			if err != nil {
-				log.Printf("Command finished with error: %v\n", err)
-			}
-			if !flag.Hold {
-				return
-			} else {
-				log.Println("Child process exited, but --hold option is set, waiting for input...")
-			}
-		case <-sigWinchChan:
-			if err := pty.InheritSize(tty, ptmx); err != nil {
-				log.Printf("Error resizing pty: %v\n", err)
-			}
-		case action := <-actionChan:
-			switch action.Type {
-			case ActionTypeExit:
-				stopChild()
-				return
-			case ActionTypeBecome:
-				stopChild()
-				arg := strings.ReplaceAll(action.Arg, "__stdin_file__", stdinFile.Name())
-				execSyscall("bash", "-c", arg)
-			case ActionTypeExecute:
-				arg := strings.ReplaceAll(action.Arg, "__stdin_file__", stdinFile.Name())
-				cmd := exec.Command("bash", "-c", arg)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err := cmd.Run(); err != nil {
-					log.Println(err)
-				}
-			}
+	// <mode>:<key>:<action> 只在该模式中生效，按键名加上模式前缀
+	if keywrap.IsModeBind(bind) {
+		mode, rest, _ := strings.Cut(bind, ":")
+		bound := make(map[string]string)
+		if err := parseBind(rest, bound); err != nil {
+			return err
+		}
+		for k, v := range bound {
+			keymap[mode+":"+k] = v
 		}
+		return nil
+	}
+	kv := strings.SplitN(bind, ":", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid bind %q, expected \"<key>:<action>\"", bind)
+	}
+	// 冒号之前是按键部分，逗号分隔多个按键；action 参数里的逗号不受影响
+	keys := strings.Split(kv[0], ",")
+	if kv[0] == "," {
+		keys = []string{","}
 	}
+	for _, key := range keys {
+		keymap[key] = strings.TrimSpace(kv[1])
+	}
+	return nil
 }
 
-type Action struct {
-	Type ActionType
-	Arg  string
+// 解析 --size 的 COLSxROWS，一边可以省略 (跟随终端) 或为负数 (比终端小多少)
+func parseSize(v string) (int, int, error) {
+	colsStr, rowsStr, ok := strings.Cut(v, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid --size %q, expected COLSxROWS", v)
+	}
+	var size [2]int
+	for i, s := range []string{colsStr, rowsStr} {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n == 0 {
+			return 0, 0, fmt.Errorf("invalid --size %q, expected COLSxROWS", v)
+		}
+		size[i] = n
+	}
+	return size[0], size[1], nil
 }
 
-type ActionType string
-
-const (
-	ActionTypeExit    ActionType = "exit"
-	ActionTypeBecome  ActionType = "become"
-	ActionTypeExecute ActionType = "execute"
-)
-
-func formatKeymap(keymap map[string]string) map[string]Action {
-	m := make(map[string]Action)
-	for k, v := range keymap {
-		var action Action
-		if v == "exit" {
-			action = Action{
-				Type: ActionTypeExit,
-			}
-		} else if strings.HasPrefix(v, "become(") {
-			action = Action{
-				Type: ActionTypeBecome,
-				Arg:  v[7 : len(v)-1],
-			}
-		} else if strings.HasPrefix(v, "execute(") {
-			action = Action{
-				Type: ActionTypeExecute,
-				Arg:  v[8 : len(v)-1],
-			}
+// 每行一个 key:action，忽略空行和 # 开头的注释
+func readBindFile(path string, keymap map[string]string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		// 只去掉行尾空白，行首的空格可能是绑定的按键本身
+		line = strings.TrimRight(line, " \t\r")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
 		}
-
-		switch {
-		case len(k) == 1:
-			m[k] = action
-		case strings.HasPrefix(k, "ctrl-") && len(k[5:]) == 1:
-			code := k[5]
-			m[fmt.Sprintf("\x1b[%d;5u", code)] = action // CSI u
-			if code >= 'a' && code <= 'z' {
-				m[string(code-'a'+1)] = action
-			}
-		case k == "enter":
-			m["\n"] = action
-		case k == "tab":
-			m["\t"] = action
-		default:
-			panic("unknown key: " + k)
+		if err := parseBind(line, keymap); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
 		}
 	}
-	return m
+	return nil
 }
 
-func execSyscall(cmd string, args ...string) {
-	binary, lookErr := exec.LookPath(cmd)
-	if lookErr != nil {
-		panic(lookErr)
+func main() {
+	log.SetFlags(0)
+	// Run 会在返回前恢复终端，这里只负责把 panic 整理成普通的错误输出
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("keywrap: panic: %v\n%s", r, debug.Stack())
+			os.Exit(2)
+		}
+	}()
+
+	flag, err := parseFlag()
+	if err != nil {
+		log.Printf("keywrap: %v\n%s\n", err, usage)
+		os.Exit(2)
 	}
-	env := os.Environ()
-	execErr := syscall.Exec(binary, append([]string{binary}, args...), env)
-	if execErr != nil {
-		panic(execErr)
+	if flag.Keys {
+		tty, closeTTY, err := keywrap.OpenTTY()
+		if err == nil {
+			err = keywrap.ShowKeys(tty, tty)
+			closeTTY()
+		}
+		if errors.Is(err, keywrap.ErrNoTerminal) {
+			log.Printf("keywrap: %v\n", err)
+			os.Exit(3)
+		}
+		if err != nil {
+			log.Printf("keywrap: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// 进入原始模式前检查按键名，写错时直接报错退出
+	if err := keywrap.ValidateKeymap(flag.Keymap); err != nil && !flag.DryRun {
+		log.Printf("keywrap: %v\n", err)
+		os.Exit(2)
+	}
+	if flag.DryRun {
+		fmt.Printf("command: %q\n", flag.Cmd)
+		for _, cmd := range flag.Fallbacks {
+			fmt.Printf("fallback: %q\n", cmd)
+		}
+		fmt.Println()
+		if !keywrap.PrintKeymap(os.Stdout, flag.Keymap) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	code, err := keywrap.Run(context.Background(), flag.Config)
+	if err != nil {
+		log.Printf("keywrap: %v\n", err)
 	}
+	os.Exit(code)
 }
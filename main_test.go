@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvBinds(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		env  string
+		want map[string]string
+	}{
+		{"q:exit;ctrl-e:execute(vim a; make)", map[string]string{"q": "exit", "ctrl-e": "execute(vim a; make)"}},
+		{"ctrl_e:exit;q:exit", map[string]string{"q": "exit"}},
+		{"w:exit;q:become(", map[string]string{"w": "exit"}},
+		{"q:become(;w:exit", map[string]string{}},
+		{"q:exitt;w:bogus(1);e:exit", map[string]string{"e": "exit"}},
+		{"x:signal(FOO);nokey;m:mode(normal)", map[string]string{"m": "mode(normal)"}},
+	}
+	for _, tt := range tests {
+		keymap := make(map[string]string)
+		readEnvBinds(tt.env, keymap)
+		if !reflect.DeepEqual(keymap, tt.want) {
+			t.Errorf("readEnvBinds(%q) = %q, want %q", tt.env, keymap, tt.want)
+		}
+	}
+}